@@ -0,0 +1,182 @@
+package xlogging
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+)
+
+// defaultBufferLimit is the default number of records buffered per request.
+const defaultBufferLimit = 1000
+
+// BufferOption configures a request buffer created by BufferRequest.
+type BufferOption func(*requestBuffer)
+
+// BufferSampleRate sets the fraction of requests, between 0 and 1, whose
+// records are all logged even if the request succeeds. Defaults to 0.
+func BufferSampleRate(rate float64) BufferOption {
+	return func(b *requestBuffer) {
+		b.sampled = rand.Float64() < rate
+	}
+}
+
+// BufferLimit sets the number of records buffered per request. When it is
+// reached, each new record evicts the oldest one, which is logged right away
+// if it would be logged on success and dropped otherwise, so that a failed
+// request logs its last records. Defaults to 1000.
+func BufferLimit(n int) BufferOption {
+	return func(b *requestBuffer) {
+		if n > 0 {
+			b.limit = n
+		}
+	}
+}
+
+// bufferedRecord is a record with the handler and context it was logged with.
+type bufferedRecord struct {
+	handler slog.Handler
+	ctx     context.Context
+	record  slog.Record
+	enabled bool // whether the handler handles the record's level
+}
+
+// requestBuffer holds the records logged with the context of one request.
+type requestBuffer struct {
+	mu      sync.Mutex
+	records []bufferedRecord
+	next    int // index of the oldest record once the buffer is full
+	limit   int
+	sampled bool
+	done    bool
+}
+
+// bufferKey is the context key of the requestBuffer added by BufferRequest.
+type bufferKey struct{}
+
+// BufferRequest returns a copy of ctx in which records of every level logged
+// with it are buffered instead of written, and a function to call at the end
+// of the request. If the request failed or was sampled, the function logs all
+// buffered records; otherwise only those at or above the logger's level.
+// Records are logged with the context they were logged with, so they carry its
+// context values. Fatal and Panic records commit the buffer as failed first.
+// Buffering is done by the handler of the logger, so it also applies to
+// records logged through Logger.Handler, e.g. with slog.New.
+//
+//	ctx, commit := xlogging.BufferRequest(r.Context(), xlogging.BufferSampleRate(0.01))
+//	defer func() { commit(rw.statusCode >= 500) }()
+func BufferRequest(ctx context.Context, opts ...BufferOption) (context.Context, func(failed bool)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	b := &requestBuffer{limit: defaultBufferLimit}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return context.WithValue(ctx, bufferKey{}, b), b.commit
+}
+
+// requestBufferFrom returns the requestBuffer of ctx, or nil.
+func requestBufferFrom(ctx context.Context) *requestBuffer {
+	if ctx == nil {
+		return nil
+	}
+	b, _ := ctx.Value(bufferKey{}).(*requestBuffer)
+	return b
+}
+
+// add buffers a record. It reports false if the buffer was already committed,
+// in which case the record should be logged directly.
+func (b *requestBuffer) add(rec bufferedRecord) bool {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return false
+	}
+	if len(b.records) < b.limit {
+		b.records = append(b.records, rec)
+		b.mu.Unlock()
+		return true
+	}
+	evicted := b.records[b.next]
+	b.records[b.next] = rec
+	b.next = (b.next + 1) % b.limit
+	all := b.sampled
+	b.mu.Unlock()
+
+	// Log outside the lock, since handlers may log with the same context
+	flush([]bufferedRecord{evicted}, all)
+	return true
+}
+
+// commit logs the buffered records, all of them if failed or sampled, and
+// ends buffering. Calls after the first are no-ops.
+func (b *requestBuffer) commit(failed bool) {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return
+	}
+	records := append(b.records[b.next:len(b.records):len(b.records)], b.records[:b.next]...)
+	b.records = nil
+	b.done = true
+	all := failed || b.sampled
+	b.mu.Unlock()
+
+	flush(records, all)
+}
+
+// flush logs records, only the enabled ones unless all is set.
+func flush(records []bufferedRecord, all bool) {
+	for _, rec := range records {
+		if all || rec.enabled {
+			_ = rec.handler.Handle(rec.ctx, rec.record)
+		}
+	}
+}
+
+// bufferHandler wraps a slog.Handler to buffer the records logged with the
+// context of a BufferRequest.
+type bufferHandler struct {
+	inner slog.Handler
+}
+
+// newBufferHandler creates a new bufferHandler wrapping the given handler.
+func newBufferHandler(inner slog.Handler) *bufferHandler {
+	return &bufferHandler{inner: inner}
+}
+
+// Enabled reports true for all levels within BufferRequest, since records of
+// every level are buffered, and defers to the wrapped handler otherwise.
+func (h *bufferHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return requestBufferFrom(ctx) != nil || h.inner.Enabled(ctx, level)
+}
+
+// Handle buffers the record within BufferRequest, or handles it if the buffer
+// was committed. Records at LevelPanic or above commit the buffer as failed first.
+func (h *bufferHandler) Handle(ctx context.Context, r slog.Record) error {
+	buf := requestBufferFrom(ctx)
+	if buf == nil {
+		return h.inner.Handle(ctx, r)
+	}
+	enabled := h.inner.Enabled(ctx, r.Level)
+	if r.Level >= LevelPanic {
+		buf.commit(true)
+	} else if buf.add(bufferedRecord{handler: h.inner, ctx: ctx, record: r.Clone(), enabled: enabled}) {
+		return nil
+	}
+	if !enabled {
+		return nil
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+// WithAttrs returns a new handler with the given attributes.
+func (h *bufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &bufferHandler{inner: h.inner.WithAttrs(attrs)}
+}
+
+// WithGroup returns a new handler with the given group name.
+func (h *bufferHandler) WithGroup(name string) slog.Handler {
+	return &bufferHandler{inner: h.inner.WithGroup(name)}
+}
@@ -0,0 +1,196 @@
+package xlogging
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// canonicalDurationKey is the attribute key of the duration of a canonical log line.
+const canonicalDurationKey = "duration"
+
+// CanonicalLine collects the attributes of a request into a single wide record,
+// a canonical log line, logged by Emit at the end of the request. Code
+// throughout the request retrieves it with Canonical. It is safe for
+// concurrent use; the methods of a nil CanonicalLine do nothing.
+type CanonicalLine struct {
+	logger Logger
+	ctx    context.Context
+	msg    string
+	start  time.Time
+
+	mu       sync.Mutex
+	level    Level
+	attrs    []slog.Attr
+	index    map[string]int // positions of the keys in attrs
+	counters map[string]int64
+	timings  map[string]time.Duration
+	emitted  bool
+}
+
+// canonicalKey is the context key of the CanonicalLine added by StartCanonical.
+type canonicalKey struct{}
+
+// levelLogger is implemented by Loggers without a handler that log at any
+// level, such as TestLogger.
+type levelLogger interface {
+	log(level Level, msg string, args ...any)
+}
+
+// StartCanonical starts a canonical log line with the given message, to be
+// logged with l at LevelInfo, or with the Logger of ctx if l is nil. The
+// returned context carries it for Canonical, and its context values are added
+// when the line is emitted.
+func StartCanonical(ctx context.Context, l Logger, msg string) (context.Context, *CanonicalLine) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if l == nil {
+		l = FromContext(ctx)
+	}
+	c := &CanonicalLine{
+		logger:   l,
+		msg:      msg,
+		start:    time.Now(),
+		level:    LevelInfo,
+		index:    make(map[string]int),
+		counters: make(map[string]int64),
+		timings:  make(map[string]time.Duration),
+	}
+	c.ctx = context.WithValue(ctx, canonicalKey{}, c)
+	return c.ctx, c
+}
+
+// Canonical returns the CanonicalLine started with ctx, or nil, which ignores
+// all calls, if there is none.
+func Canonical(ctx context.Context) *CanonicalLine {
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(canonicalKey{}).(*CanonicalLine)
+	return c
+}
+
+// AddAttrs adds attributes, given as key-value pairs or slog.Attr values as for
+// Logger.Info. A value replaces an earlier one with the same key.
+func (c *CanonicalLine) AddAttrs(args ...any) {
+	if c == nil {
+		return
+	}
+	attrs := slog.Group("", args...).Value.Group()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, a := range attrs {
+		if i, ok := c.index[a.Key]; ok {
+			c.attrs[i] = a
+			continue
+		}
+		c.index[a.Key] = len(c.attrs)
+		c.attrs = append(c.attrs, a)
+	}
+}
+
+// Count adds delta to the counter of the given name, e.g. the number of database queries.
+func (c *CanonicalLine) Count(name string, delta int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters[name] += delta
+}
+
+// AddDuration adds d to the timing of the given name.
+func (c *CanonicalLine) AddDuration(name string, d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timings[name] += d
+}
+
+// Time starts timing an operation and returns a function that adds its
+// duration to the timing of the given name:
+//
+//	defer xlogging.Canonical(ctx).Time("db")()
+func (c *CanonicalLine) Time(name string) func() {
+	if c == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { c.AddDuration(name, time.Since(start)) }
+}
+
+// SetLevel sets the level of the line, e.g. LevelError for failed requests.
+func (c *CanonicalLine) SetLevel(level Level) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.level = level
+}
+
+// Emit logs the line with its attributes, counters, timings and the duration
+// since StartCanonical, at the level set by SetLevel and with the caller of
+// Emit as source. Counters and timings are added sorted by name.
+// Calls after the first do nothing.
+func (c *CanonicalLine) Emit() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.emitted {
+		c.mu.Unlock()
+		return
+	}
+	c.emitted = true
+	args := make([]any, 0, len(c.attrs)+len(c.counters)+len(c.timings)+1)
+	for _, a := range c.attrs {
+		args = append(args, a)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.counters)) {
+		args = append(args, slog.Int64(name, c.counters[name]))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.timings)) {
+		args = append(args, slog.Duration(name, c.timings[name]))
+	}
+	args = append(args, slog.Duration(canonicalDurationKey, time.Since(c.start)))
+	level := c.level
+	c.mu.Unlock()
+
+	h := c.logger.Handler()
+	if h == nil {
+		// Loggers without a handler log at the closest of their methods,
+		// unless they can log at any level
+		if l, ok := c.logger.(levelLogger); ok {
+			l.log(level, c.msg, args...)
+			return
+		}
+		switch {
+		case level >= LevelError:
+			c.logger.ErrorContext(c.ctx, c.msg, args...)
+		case level >= LevelWarn:
+			c.logger.WarnContext(c.ctx, c.msg, args...)
+		case level >= LevelInfo:
+			c.logger.InfoContext(c.ctx, c.msg, args...)
+		default:
+			c.logger.DebugContext(c.ctx, c.msg, args...)
+		}
+		return
+	}
+	if !h.Enabled(c.ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:]) // skip [Callers, Emit]
+	r := slog.NewRecord(time.Now(), level, c.msg, pcs[0])
+	r.Add(args...)
+	_ = h.Handle(c.ctx, r)
+}
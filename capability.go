@@ -0,0 +1,272 @@
+package xlogging
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ColorDepth is the number of colors an output can display.
+type ColorDepth int
+
+// Color depths.
+const (
+	ColorDepthNone      ColorDepth = iota // no color support
+	ColorDepth16                          // basic ANSI colors
+	ColorDepth256                         // xterm 256-color palette
+	ColorDepthTrueColor                   // 24-bit RGB
+)
+
+// ciColorVars are environment variables set by CI systems whose log viewers render ANSI colors.
+var ciColorVars = []string{
+	"GITHUB_ACTIONS",
+	"GITLAB_CI",
+	"BUILDKITE",
+	"CIRCLECI",
+	"DRONE",
+	"TRAVIS",
+	"APPVEYOR",
+	"TEAMCITY_VERSION",
+	"TF_BUILD",
+}
+
+// detectColorDepth returns the color depth supported by w.
+// It honors, in order: NO_COLOR, FORCE_COLOR, CLICOLOR_FORCE, CLICOLOR=0,
+// TERM=dumb, terminal detection for writers exposing Fd(), and CI
+// environments that render colors of the standard streams in their logs.
+// The depth itself is derived from COLORTERM and TERM.
+func detectColorDepth(w io.Writer) ColorDepth {
+	return colorDepthFromEnv(w, os.Getenv)
+}
+
+// colorDepthFromEnv implements detectColorDepth with the given environment lookup.
+func colorDepthFromEnv(w io.Writer, getenv func(string) string) ColorDepth {
+	if getenv("NO_COLOR") != "" {
+		return ColorDepthNone
+	}
+
+	if force := strings.ToLower(strings.TrimSpace(getenv("FORCE_COLOR"))); force != "" {
+		switch force {
+		case "0", "false":
+			return ColorDepthNone
+		case "2":
+			return max(ColorDepth256, termColorDepth(getenv))
+		case "3":
+			return ColorDepthTrueColor
+		default:
+			return termColorDepth(getenv)
+		}
+	}
+	if force := getenv("CLICOLOR_FORCE"); force != "" && force != "0" {
+		return termColorDepth(getenv)
+	}
+
+	if getenv("CLICOLOR") == "0" || strings.EqualFold(getenv("TERM"), "dumb") {
+		return ColorDepthNone
+	}
+
+	if isTerminalWriter(w) {
+		return termColorDepth(getenv)
+	}
+	isStdStream := w == io.Writer(os.Stdout) || w == io.Writer(os.Stderr)
+	if isStdStream && (getenv("CI") != "" || anyEnvSet(getenv, ciColorVars)) {
+		return termColorDepth(getenv)
+	}
+	return ColorDepthNone
+}
+
+// termColorDepth derives the color depth from COLORTERM and TERM.
+// It never returns less than ColorDepth16.
+func termColorDepth(getenv func(string) string) ColorDepth {
+	colorTerm := strings.ToLower(getenv("COLORTERM"))
+	if colorTerm == "truecolor" || colorTerm == "24bit" {
+		return ColorDepthTrueColor
+	}
+	term := strings.ToLower(getenv("TERM"))
+	switch {
+	case strings.Contains(term, "truecolor"), strings.Contains(term, "24bit"), strings.HasSuffix(term, "-direct"):
+		return ColorDepthTrueColor
+	case strings.Contains(term, "256color"):
+		return ColorDepth256
+	default:
+		return ColorDepth16
+	}
+}
+
+// anyEnvSet reports whether any of the given environment variables is set.
+func anyEnvSet(getenv func(string) string, keys []string) bool {
+	for _, key := range keys {
+		if getenv(key) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isTerminalWriter reports whether w exposes a file descriptor that is a terminal.
+func isTerminalWriter(w io.Writer) bool {
+	f, ok := w.(interface{ Fd() uintptr })
+	if !ok {
+		return false
+	}
+	return isTerminal(int(f.Fd()))
+}
+
+// terminalWidth returns the width in columns of the terminal w writes to.
+// For other writers it falls back to the COLUMNS environment variable, and
+// returns 0 if the width is unknown.
+func terminalWidth(w io.Writer) int {
+	if f, ok := w.(interface{ Fd() uintptr }); ok && isTerminal(int(f.Fd())) {
+		if width, _, err := term.GetSize(int(f.Fd())); err == nil {
+			return width
+		}
+	}
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	return max(width, 0)
+}
+
+// ansi16 holds the RGB values of the 16 basic ANSI colors (xterm defaults).
+var ansi16 = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// cubeLevels are the component values of the 6x6x6 color cube of the 256-color palette.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// forDepth returns the color converted to the given depth.
+// Extended colors are mapped to the nearest color the depth supports;
+// ColorDepthNone yields ColorNone. Concatenated sequences are converted one by one.
+func (c Color) forDepth(depth ColorDepth) Color {
+	if depth == ColorDepthNone {
+		return ColorNone
+	}
+	if depth == ColorDepthTrueColor || (!strings.Contains(string(c), "38;") && !strings.Contains(string(c), "48;")) {
+		return c
+	}
+
+	var b strings.Builder
+	for _, seq := range strings.SplitAfter(string(c), "m") {
+		params, ok := strings.CutPrefix(seq, "\033[")
+		if !ok {
+			b.WriteString(seq)
+			continue
+		}
+		params, ok = strings.CutSuffix(params, "m")
+		if !ok {
+			b.WriteString(seq)
+			continue
+		}
+		b.WriteString("\033[" + sgrForDepth(params, depth) + "m")
+	}
+	return Color(b.String())
+}
+
+// sgrForDepth converts the extended colors among the SGR parameters to the given depth.
+func sgrForDepth(params string, depth ColorDepth) string {
+	parts := strings.Split(params, ";")
+	out := make([]string, 0, len(parts))
+	for i := 0; i < len(parts); i++ {
+		p := parts[i]
+		if (p != "38" && p != "48") || i+1 >= len(parts) {
+			out = append(out, p)
+			continue
+		}
+		background := p == "48"
+
+		var rgb [3]int
+		index := -1
+		switch {
+		case parts[i+1] == "5" && i+2 < len(parts):
+			n, _ := strconv.Atoi(parts[i+2])
+			index = n
+			rgb = rgbFrom256(n)
+			i += 2
+		case parts[i+1] == "2" && i+4 < len(parts):
+			for j := range rgb {
+				rgb[j], _ = strconv.Atoi(parts[i+2+j])
+			}
+			i += 4
+		default:
+			out = append(out, p)
+			continue
+		}
+
+		if depth == ColorDepth256 {
+			if index < 0 {
+				index = nearest256(rgb)
+			}
+			out = append(out, p, "5", strconv.Itoa(index))
+			continue
+		}
+		out = append(out, sgr16(nearest16(rgb), background))
+	}
+	return strings.Join(out, ";")
+}
+
+// rgbFrom256 returns the RGB value of a 256-color palette index.
+func rgbFrom256(n int) [3]int {
+	switch {
+	case n < 0:
+		return ansi16[0]
+	case n < 16:
+		return ansi16[n]
+	case n < 232:
+		n -= 16
+		return [3]int{cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]}
+	case n < 256:
+		g := 8 + (n-232)*10
+		return [3]int{g, g, g}
+	default:
+		return ansi16[15]
+	}
+}
+
+// nearest256 returns the 256-color palette index closest to rgb,
+// considering the color cube and the grayscale ramp.
+func nearest256(rgb [3]int) int {
+	best, bestDist := 0, -1
+	for n := 16; n < 256; n++ {
+		if d := colorDistance(rgb, rgbFrom256(n)); bestDist < 0 || d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// nearest16 returns the basic ANSI color index closest to rgb.
+func nearest16(rgb [3]int) int {
+	best, bestDist := 0, -1
+	for n, c := range ansi16 {
+		if d := colorDistance(rgb, c); bestDist < 0 || d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// colorDistance returns the squared euclidean distance between two RGB values.
+func colorDistance(a, b [3]int) int {
+	d := 0
+	for i := range a {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return d
+}
+
+// sgr16 returns the SGR parameter selecting a basic ANSI color.
+func sgr16(n int, background bool) string {
+	base := 30
+	if n >= 8 {
+		base, n = 90, n-8
+	}
+	if background {
+		base += 10
+	}
+	return strconv.Itoa(base + n)
+}
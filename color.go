@@ -1,188 +1,671 @@
 package xlogging
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"log/slog"
+	"runtime"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 // ANSI color codes.
 const (
-	colorReset  = "\033[0m"
-	colorRed    = "\033[31m"
-	colorGreen  = "\033[32m"
-	colorYellow = "\033[33m"
-	colorBlue   = "\033[34m"
-	colorCyan   = "\033[36m"
-	colorGray   = "\033[90m"
-	colorBold   = "\033[1m"
+	colorReset = "\033[0m"
+	colorBold  = "\033[1m"
+)
+
+// Color is an ANSI escape sequence used to colorize console output.
+type Color string
+
+// Console colors.
+const (
+	ColorNone      Color = ""
+	ColorRed       Color = "\033[31m"
+	ColorGreen     Color = "\033[32m"
+	ColorYellow    Color = "\033[33m"
+	ColorBlue      Color = "\033[34m"
+	ColorMagenta   Color = "\033[35m"
+	ColorCyan      Color = "\033[36m"
+	ColorWhite     Color = "\033[37m"
+	ColorGray      Color = "\033[90m"
+	ColorBrightRed Color = "\033[91m"
 )
 
 // colorHandler is a slog.Handler that outputs colored text.
+// Each record is rendered into a pooled buffer and written with a single Write call.
 type colorHandler struct {
-	w           io.Writer
-	level       slog.Leveler
-	addSource   bool
-	attrs       []slog.Attr
-	groups      []string
-	mu          *sync.Mutex
-	contextKeys []ContextKey
+	w            io.Writer
+	level        slog.Leveler
+	addSource    bool
+	preformatted []byte // rendered attributes added with WithAttrs
+	preBlocks    []byte // rendered blocks of expanded attributes added with WithAttrs
+	preEnds      []int  // end offsets of the attributes in preformatted, in aligned layout
+	groupPrefix  string // open groups joined with dots, e.g. "request.headers."
+	mu           *sync.Mutex
+	contextKeys  []ContextKey
+	contextNames []string // displayed keys of the values of contextKeys
+	extractors   []ContextExtractor
+	ctxOutput    *ContextOutput
+	preset       []string // keys added with WithAttrs since the last group, for conflicts
+	redactor     *redactor
+	absoluteTime bool // render times absolutely, for attributes pre-rendered by WithAttrs
+	palette      *palette
+	highlight    *highlighter
+	expanded     bool
+	timeMode     TimeMode
+	lastTime     *atomic.Int64     // time of the previous record in Unix nanoseconds, for TimeModeDelta
+	layout       *alignedLayout    // nil for the compact layout
+	requests     *requestColorizer // nil unless per-request colors are enabled
+	sourceLinks  *SourceLinks
+	sanitize     bool // escape control characters in messages and keys
+}
+
+// renderState is the state of rendering one record or one WithAttrs call.
+type renderState struct {
+	blocks *[]byte // blocks rendered below the line in expanded mode, or nil
+	ends   *[]int  // end offsets of the rendered attributes in aligned layout, or nil
+}
+
+// maxPooledBuffer is the capacity above which render buffers are not returned to the pool,
+// so that an occasional huge record does not pin memory.
+const maxPooledBuffer = 64 << 10
+
+// bufferPool holds render buffers of the colorHandler.
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
 }
 
 // colorHandlerOptions configures the colorHandler.
 type colorHandlerOptions struct {
-	Level       slog.Leveler
-	AddSource   bool
-	ContextKeys []ContextKey
+	Level           slog.Leveler
+	AddSource       bool
+	ContextKeys     []ContextKey
+	Extractors      []ContextExtractor
+	ContextOutput   *ContextOutput // names, group and conflict policy of context-derived attributes
+	Redactor        *redactor      // redacts context-derived attributes, which the color handler adds itself
+	ColorDepth      ColorDepth     // colors are converted to this depth; defaults to ColorDepth16
+	Theme           *Theme         // defaults to DarkTheme
+	HighlightKeys   []string
+	HighlightValues []string
+	Expanded        bool // render composite values, JSON and multi-line strings as blocks below the record
+	TimeMode        TimeMode
+	Layout          *Layout        // aligned layout; nil for the compact layout
+	RequestColors   *RequestColors // per-request colors; nil to disable
+	SourceLinks     *SourceLinks   // rendering of source locations; nil for plain absolute paths
+	Verbatim        bool           // write messages and keys without escaping control characters
+}
+
+// highlighter selects attributes to render with the theme's highlight color.
+type highlighter struct {
+	keys   map[string]struct{}
+	values [][]byte
+}
+
+// newHighlighter creates a highlighter, or returns nil if nothing is highlighted.
+func newHighlighter(keys, values []string) *highlighter {
+	if len(keys) == 0 && len(values) == 0 {
+		return nil
+	}
+	h := &highlighter{
+		keys:   make(map[string]struct{}, len(keys)),
+		values: make([][]byte, 0, len(values)),
+	}
+	for _, k := range keys {
+		h.keys[k] = struct{}{}
+	}
+	for _, v := range values {
+		if v != "" {
+			h.values = append(h.values, []byte(v))
+		}
+	}
+	return h
+}
+
+// matchKey reports whether the attribute key, with or without its group prefix, is highlighted.
+func (h *highlighter) matchKey(prefix, key string) bool {
+	if h == nil || len(h.keys) == 0 {
+		return false
+	}
+	_, ok := h.keys[key]
+	if !ok && prefix != "" {
+		_, ok = h.keys[prefix+key]
+	}
+	return ok
+}
+
+// matchValue reports whether the rendered value contains a highlighted value.
+func (h *highlighter) matchValue(value []byte) bool {
+	if h == nil {
+		return false
+	}
+	for _, v := range h.values {
+		if bytes.Contains(value, v) {
+			return true
+		}
+	}
+	return false
 }
 
 // newColorHandler creates a new colorHandler.
 func newColorHandler(w io.Writer, opts *colorHandlerOptions) *colorHandler {
 	h := &colorHandler{
-		w:  w,
-		mu: &sync.Mutex{},
+		w:        w,
+		mu:       &sync.Mutex{},
+		lastTime: &atomic.Int64{},
+		sanitize: true,
 	}
+	theme := DarkTheme()
+	depth := ColorDepth16
 	if opts != nil {
 		h.level = opts.Level
 		h.addSource = opts.AddSource
+		h.expanded = opts.Expanded
+		h.timeMode = opts.TimeMode
+		h.sanitize = !opts.Verbatim
+		if opts.Layout != nil {
+			h.layout = newAlignedLayout(*opts.Layout, len(opts.ContextKeys), terminalWidth(w))
+		}
 		h.contextKeys = opts.ContextKeys
+		h.extractors = opts.Extractors
+		h.ctxOutput = opts.ContextOutput
+		h.redactor = opts.Redactor
+		h.highlight = newHighlighter(opts.HighlightKeys, opts.HighlightValues)
+		if opts.Theme != nil {
+			theme = *opts.Theme
+		}
+		if opts.ColorDepth != ColorDepthNone {
+			depth = opts.ColorDepth
+		}
 	}
 	if h.level == nil {
 		h.level = slog.LevelInfo
 	}
+	h.palette = newPalette(theme, depth)
+	if opts != nil && opts.RequestColors != nil {
+		h.requests = newRequestColorizer(*opts.RequestColors, depth)
+	}
+	for _, key := range h.contextKeys {
+		name := h.ctxOutput.name(key)
+		if group := h.ctxOutput.group(); group != "" {
+			name = group + "." + name
+		}
+		h.contextNames = append(h.contextNames, h.text(name))
+	}
+	h.sourceLinks = &SourceLinks{}
+	if opts != nil && opts.SourceLinks != nil {
+		h.sourceLinks = opts.SourceLinks
+	}
 	return h
 }
 
-// Enabled reports whether the handler handles records at the given level.
-func (h *colorHandler) Enabled(_ context.Context, level slog.Level) bool {
+// Enabled reports whether the handler handles records at the given level,
+// which the context may override with WithLevelOverride.
+func (h *colorHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if override, ok := levelOverride(ctx); ok {
+		return level >= override
+	}
 	return level >= h.level.Level()
 }
 
 // Handle handles the record, outputting colored text.
 func (h *colorHandler) Handle(ctx context.Context, r slog.Record) error {
+	bufp := bufferPool.Get().(*[]byte)
+	var st renderState
+	if h.expanded {
+		st.blocks = bufferPool.Get().(*[]byte)
+		*st.blocks = append((*st.blocks)[:0], h.preBlocks...)
+		defer putBuffer(st.blocks)
+	}
+	buf := h.appendRecord((*bufp)[:0], &st, ctx, r)
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	_, err := h.w.Write(buf)
+	h.mu.Unlock()
 
-	// Time
-	timeStr := r.Time.Format(time.TimeOnly)
-	fmt.Fprintf(h.w, "%s%s%s ", colorGray, timeStr, colorReset)
+	*bufp = buf
+	putBuffer(bufp)
+	return err
+}
 
-	// Level with color
-	levelColor := h.levelColor(r.Level)
-	levelStr := h.levelString(r.Level)
-	fmt.Fprintf(h.w, "%s%s%-5s%s ", levelColor, colorBold, levelStr, colorReset)
+// putBuffer returns a render buffer to the pool unless it has grown too large.
+func putBuffer(b *[]byte) {
+	if cap(*b) <= maxPooledBuffer {
+		bufferPool.Put(b)
+	}
+}
 
-	// Message
-	fmt.Fprintf(h.w, "%s%s%s", colorBold, r.Message, colorReset)
+// appendRecord renders the record as one line of colored text,
+// followed by the blocks of expanded values.
+func (h *colorHandler) appendRecord(buf []byte, st *renderState, ctx context.Context, r slog.Record) []byte {
+	p := h.palette
 
-	// Context values
-	if ctx != nil && len(h.contextKeys) > 0 {
-		for _, key := range h.contextKeys {
-			if v := ctx.Value(key); v != nil {
-				if s, ok := v.(string); ok && s != "" {
-					fmt.Fprintf(h.w, " %s%s%s=%s%s%s", colorCyan, string(key), colorReset, colorGray, s, colorReset)
-				}
+	// Gutter in the color of the request; added to the following lines at the end
+	var gutter string
+	if h.requests != nil && h.requests.gutter {
+		gutter = gutterBlank
+		if v, ok := contextValue(ctx, h.requests.key); ok {
+			gutter = h.requests.color(v.String()) + gutterMarker + colorReset
+		}
+		buf = append(buf, gutter...)
+	}
+	recordStart := len(buf)
+
+	// Time, omitted for records without one
+	if !r.Time.IsZero() {
+		var prev time.Time
+		if h.timeMode == TimeModeDelta {
+			if nanos := h.lastTime.Swap(r.Time.UnixNano()); nanos != 0 {
+				prev = time.Unix(0, nanos)
 			}
 		}
+		buf = append(buf, p.time...)
+		buf = appendTimestamp(buf, h.timeMode, r.Time, prev)
+		buf = append(buf, colorReset...)
+		buf = append(buf, ' ')
+	}
+
+	// Level with color, padded to five characters, or to the longest level
+	// name in aligned layout
+	buf = append(buf, h.levelColor(r.Level)...)
+	buf = append(buf, colorBold...)
+	levelStr := h.levelString(r.Level)
+	buf = append(buf, levelStr...)
+	width := 5
+	if h.layout != nil {
+		width = levelNameWidth()
 	}
+	buf = appendSpaces(buf, width-utf8.RuneCountInString(levelStr))
+	buf = append(buf, colorReset...)
+	buf = append(buf, ' ')
 
-	// Pre-set attributes
-	for _, attr := range h.attrs {
-		h.writeAttr(attr, h.groups)
+	// Message, padded to its column in aligned layout
+	buf = append(buf, p.message...)
+	msgStart := len(buf)
+	buf = h.appendText(buf, r.Message)
+	if h.layout != nil {
+		n := utf8.RuneCount(buf[msgStart:])
+		buf = append(buf, colorReset...)
+		buf = appendSpaces(buf, h.layout.messageColumn(n)-n)
+	} else {
+		buf = append(buf, colorReset...)
+	}
+
+	// Source location; at the end of the line in aligned layout, so that it does not shift the columns
+	if h.layout == nil {
+		buf = h.appendSource(buf, r.PC)
+	}
+
+	// Context values; the other context-derived attributes are extracted once, here,
+	// and rendered after the record attributes
+	var extra []slog.Attr
+	if ctx != nil {
+		extra = h.extraContextAttrs(ctx)
+	}
+	var skipCtx, skipRecord keySet
+	if h.ctxOutput.conflict() != ContextConflictKeepBoth && ctx != nil {
+		skipCtx, skipRecord = h.contextConflicts(ctx, r, extra)
+	}
+	for i, key := range h.contextKeys {
+		v, ok := contextValue(ctx, key)
+		if ok {
+			v = h.redactContextAttr(slog.Attr{Key: h.ctxOutput.name(key), Value: v}).Value
+		}
+		if ok && h.groupPrefix == "" && skipCtx.has(h.conflictKey(h.ctxOutput.name(key))) {
+			ok = false
+		}
+		buf = h.appendContextValue(buf, i, key, v, ok)
+	}
+
+	if h.layout != nil {
+		ends := make([]int, 0, 16)
+		st.ends = &ends
+	}
+	attrStart := len(buf)
+
+	// Pre-set attributes, rendered with the groups open when they were added
+	buf = append(buf, h.preformatted...)
+	if st.ends != nil {
+		for _, end := range h.preEnds {
+			*st.ends = append(*st.ends, attrStart+end)
+		}
 	}
 
 	// Record attributes
 	r.Attrs(func(a slog.Attr) bool {
-		h.writeAttr(a, h.groups)
+		if !skipRecord.has(a.Key) {
+			buf = h.appendAttr(buf, st, a, h.groupPrefix)
+		}
 		return true
 	})
 
-	fmt.Fprintln(h.w)
-	return nil
+	// Context attributes, added like record attributes as by contextHandler
+	if len(extra) > 0 {
+		prefix := h.groupPrefix
+		if group := h.ctxOutput.group(); group != "" {
+			prefix += h.text(group) + "."
+		}
+		for _, a := range extra {
+			if !skipCtx.has(h.conflictKey(a.Key)) {
+				buf = h.appendAttr(buf, st, a, prefix)
+			}
+		}
+	}
+
+	if h.layout != nil {
+		if h.addSource && r.PC != 0 {
+			buf = h.appendSource(buf, r.PC)
+			*st.ends = append(*st.ends, len(buf))
+		}
+		width := h.layout.width
+		if gutter != "" && width > 0 {
+			width -= len(gutterBlank)
+		}
+		col := visibleWidth(buf[recordStart:attrStart])
+		buf = wrap(buf, attrStart, *st.ends, col, width, col+1)
+	}
+
+	buf = append(buf, '\n')
+	if st.blocks != nil {
+		buf = append(buf, *st.blocks...)
+	}
+	if gutter != "" {
+		buf = prefixLines(buf, recordStart, []byte(gutter))
+	}
+	return buf
 }
 
-// writeAttr writes a single attribute with proper formatting.
-func (h *colorHandler) writeAttr(a slog.Attr, groups []string) {
-	if a.Equal(slog.Attr{}) {
-		return
+// prefixLines inserts prefix at the start of every line of buf[start:] but the first.
+func prefixLines(buf []byte, start int, prefix []byte) []byte {
+	for i := start; i < len(buf)-1; i++ {
+		if buf[i] == '\n' {
+			buf = slices.Insert(buf, i+1, prefix...)
+			i += len(prefix)
+		}
+	}
+	return buf
+}
+
+// appendSource renders the source location of the record with the given PC, if enabled.
+func (h *colorHandler) appendSource(buf []byte, pc uintptr) []byte {
+	if !h.addSource || pc == 0 {
+		return buf
 	}
+	frames := runtime.CallersFrames([]uintptr{pc})
+	frame, _ := frames.Next()
+	buf = append(buf, ' ')
+	buf = append(buf, h.palette.source...)
+	buf = append(buf, slog.SourceKey+"="...)
+	buf = h.sourceLinks.appendSourceLocation(buf, frame.File, frame.Line)
+	return append(buf, colorReset...)
+}
 
-	key := a.Key
-	for i := len(groups) - 1; i >= 0; i-- {
-		key = groups[i] + "." + key
+// extraContextAttrs returns the context-derived attributes other than the values
+// of the context keys: those added by AppendCtx and those of the extractors.
+func (h *colorHandler) extraContextAttrs(ctx context.Context) []slog.Attr {
+	attrs := ctxAttrs(ctx)
+	if len(h.extractors) > 0 {
+		attrs = slices.Clip(attrs)
+		for _, extract := range h.extractors {
+			attrs = append(attrs, extract(ctx)...)
+		}
+	}
+	if h.redactor != nil && h.redactor.active() && len(attrs) > 0 {
+		redacted := make([]slog.Attr, len(attrs))
+		for i, a := range attrs {
+			redacted[i] = h.redactContextAttr(a)
+		}
+		attrs = redacted
+	}
+	return attrs
+}
+
+// redactContextAttr redacts a context-derived attribute as redactHandler redacts
+// those added by contextHandler, including the context group, if any.
+func (h *colorHandler) redactContextAttr(a slog.Attr) slog.Attr {
+	if h.redactor == nil || !h.redactor.active() {
+		return a
+	}
+	group := h.ctxOutput.group()
+	if group == "" {
+		return h.redactor.redact(a)
+	}
+	g := h.redactor.redact(slog.Attr{Key: group, Value: slog.GroupValue(a)})
+	if g.Value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, redactedValue)
+	}
+	return g.Value.Group()[0]
+}
+
+// conflictKey returns the key that a context-derived attribute of the given
+// name occupies at the level of the record attributes: the context group, if any.
+func (h *colorHandler) conflictKey(name string) string {
+	if group := h.ctxOutput.group(); group != "" {
+		return group
+	}
+	return name
+}
+
+// contextConflicts returns the keys of the context-derived attributes and of the
+// record attributes to omit because of conflicts between them; extra holds the
+// result of extraContextAttrs. Values of context keys are rendered at the top
+// level and conflict only with ungrouped attributes.
+func (h *colorHandler) contextConflicts(ctx context.Context, r slog.Record, extra []slog.Attr) (skipCtx, skipRecord keySet) {
+	names := make(keySet)
+	if h.groupPrefix == "" {
+		for _, key := range h.contextKeys {
+			if _, ok := contextValue(ctx, key); ok {
+				names[h.conflictKey(h.ctxOutput.name(key))] = struct{}{}
+			}
+		}
+	}
+	for _, a := range extra {
+		names[h.conflictKey(a.Key)] = struct{}{}
+	}
+
+	conflicts := make(keySet)
+	for key := range recordKeys(r, h.preset) {
+		if names.has(key) {
+			conflicts[key] = struct{}{}
+		}
+	}
+	if h.ctxOutput.conflict() == ContextConflictPreferRecord {
+		return conflicts, nil
+	}
+	return nil, conflicts
+}
+
+// appendContextValue renders the value of the i-th context key, if the context holds one.
+// In aligned layout, values are padded to their column, and missing values leave it blank.
+func (h *colorHandler) appendContextValue(buf []byte, i int, key ContextKey, v slog.Value, ok bool) []byte {
+	name := h.contextNames[i]
+	if !ok {
+		if h.layout != nil {
+			if width := h.layout.contextColumn(i, -1); width > 0 {
+				buf = appendSpaces(buf, utf8.RuneCountInString(name)+width+2)
+			}
+		}
+		return buf
+	}
+
+	p := h.palette
+	buf = append(buf, ' ')
+	buf = append(buf, p.key...)
+	buf = append(buf, name...)
+	buf = append(buf, colorReset...)
+	buf = append(buf, '=')
+	var start int
+	if h.requests != nil && key == h.requests.key {
+		id := v.String()
+		buf = append(buf, h.requests.color(id)...)
+		start = len(buf)
+		buf = h.requests.appendTag(buf, id)
+	} else {
+		buf = append(buf, p.context...)
+		start = len(buf)
+		buf = appendValue(buf, v)
+	}
+	buf = quoteFrom(buf, start)
+	n := utf8.RuneCount(buf[start:])
+	buf = append(buf, colorReset...)
+	if h.layout != nil {
+		buf = appendSpaces(buf, h.layout.contextColumn(i, n)-n)
+	}
+	return buf
+}
+
+// appendAttr renders a single attribute, prefixing its key with the given groups.
+// In expanded mode, expandable values are rendered into st.blocks instead of the line.
+func (h *colorHandler) appendAttr(buf []byte, st *renderState, a slog.Attr, prefix string) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return buf
 	}
 
 	if a.Value.Kind() == slog.KindGroup {
-		attrs := a.Value.Group()
-		newGroups := append(groups, a.Key)
-		for _, ga := range attrs {
-			h.writeAttr(ga, newGroups)
+		if a.Key != "" {
+			prefix += h.text(a.Key) + "."
 		}
-		return
+		for _, ga := range a.Value.Group() {
+			buf = h.appendAttr(buf, st, ga, prefix)
+		}
+		return buf
+	}
+
+	p := h.palette
+	keyColor, valueColor := p.key, p.value(a.Value)
+	keyHighlighted := h.highlight.matchKey(prefix, a.Key)
+	if keyHighlighted {
+		keyColor, valueColor = p.highlight, p.highlight
+	}
+
+	if st.blocks != nil && expandable(a.Value) {
+		*st.blocks = h.appendBlock(*st.blocks, keyColor, prefix+h.text(a.Key), a.Value)
+		return buf
+	}
+
+	buf = append(buf, ' ')
+	buf = append(buf, keyColor...)
+	buf = append(buf, prefix...)
+	buf = h.appendText(buf, a.Key)
+	buf = append(buf, colorReset...)
+	buf = append(buf, '=')
+
+	colorStart := len(buf)
+	buf = append(buf, valueColor...)
+	valueStart := len(buf)
+	buf = h.appendValue(buf, a.Value)
+	buf = quoteFrom(buf, valueStart)
+	if !keyHighlighted && h.highlight.matchValue(buf[valueStart:]) {
+		buf = slices.Replace(buf, colorStart, valueStart, []byte(p.highlight)...)
 	}
+	buf = append(buf, colorReset...)
+	if st.ends != nil {
+		*st.ends = append(*st.ends, len(buf))
+	}
+	return buf
+}
+
+// appendValue renders v as appendValue does, but renders times absolutely if
+// the handler pre-renders attributes for WithAttrs.
+func (h *colorHandler) appendValue(buf []byte, v slog.Value) []byte {
+	if h.absoluteTime && v.Kind() == slog.KindTime {
+		return v.Time().AppendFormat(buf, time.RFC3339Nano)
+	}
+	return appendValue(buf, v)
+}
 
-	fmt.Fprintf(h.w, " %s%s%s=%v", colorCyan, key, colorReset, a.Value.Any())
+// appendValue renders a resolved, non-group value without reflection for the common kinds.
+// Durations, floats, byte counts and times are humanized for reading.
+func appendValue(buf []byte, v slog.Value) []byte {
+	switch v.Kind() {
+	case slog.KindString:
+		return append(buf, v.String()...)
+	case slog.KindInt64:
+		return strconv.AppendInt(buf, v.Int64(), 10)
+	case slog.KindUint64:
+		return strconv.AppendUint(buf, v.Uint64(), 10)
+	case slog.KindFloat64:
+		return appendFloat(buf, v.Float64())
+	case slog.KindBool:
+		return strconv.AppendBool(buf, v.Bool())
+	case slog.KindDuration:
+		return appendDuration(buf, v.Duration())
+	case slog.KindTime:
+		return appendTime(buf, v.Time(), time.Now())
+	}
+
+	switch x := v.Any().(type) {
+	case nil:
+		return append(buf, "<nil>"...)
+	case Bytes:
+		return appendBytes(buf, x)
+	case error:
+		return append(buf, x.Error()...)
+	case fmt.Stringer:
+		return append(buf, x.String()...)
+	case []byte:
+		return append(buf, x...)
+	default:
+		return fmt.Append(buf, x)
+	}
 }
 
 // WithAttrs returns a new handler with the given attributes.
+// The attributes are rendered once, with the groups opened so far.
 func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	newAttrs := make([]slog.Attr, len(h.attrs)+len(attrs))
-	copy(newAttrs, h.attrs)
-	copy(newAttrs[len(h.attrs):], attrs)
-	return &colorHandler{
-		w:           h.w,
-		level:       h.level,
-		addSource:   h.addSource,
-		attrs:       newAttrs,
-		groups:      h.groups,
-		mu:          h.mu,
-		contextKeys: h.contextKeys,
+	if len(attrs) == 0 {
+		return h
 	}
+	h2 := *h
+	h2.preformatted = slices.Clip(h.preformatted)
+	var st renderState
+	if h.expanded {
+		h2.preBlocks = slices.Clip(h.preBlocks)
+		st.blocks = &h2.preBlocks
+	}
+	if h.layout != nil {
+		h2.preEnds = slices.Clip(h.preEnds)
+		st.ends = &h2.preEnds
+	}
+	// Relative times would not change with the records, so pre-render them absolutely
+	hr := *h
+	hr.absoluteTime = true
+	for _, a := range attrs {
+		h2.preformatted = hr.appendAttr(h2.preformatted, &st, a, h.groupPrefix)
+	}
+	if h.ctxOutput.conflict() != ContextConflictKeepBoth {
+		h2.preset = appendPresetKeys(h.preset, attrs)
+	}
+	return &h2
 }
 
 // WithGroup returns a new handler with the given group name.
 func (h *colorHandler) WithGroup(name string) slog.Handler {
-	newGroups := make([]string, len(h.groups)+1)
-	copy(newGroups, h.groups)
-	newGroups[len(h.groups)] = name
-	return &colorHandler{
-		w:           h.w,
-		level:       h.level,
-		addSource:   h.addSource,
-		attrs:       h.attrs,
-		groups:      newGroups,
-		mu:          h.mu,
-		contextKeys: h.contextKeys,
+	if name == "" {
+		return h
 	}
+	h2 := *h
+	h2.groupPrefix = h.groupPrefix + h.text(name) + "."
+	h2.preset = nil
+	return &h2
 }
 
 // levelColor returns the ANSI color for the given level.
 func (h *colorHandler) levelColor(level slog.Level) string {
-	switch {
-	case level >= slog.LevelError:
-		return colorRed
-	case level >= slog.LevelWarn:
-		return colorYellow
-	case level >= slog.LevelInfo:
-		return colorGreen
-	default:
-		return colorBlue
-	}
+	return h.palette.level(level)
 }
 
 // levelString returns the string representation of the level.
 func (h *colorHandler) levelString(level slog.Level) string {
-	switch {
-	case level >= slog.LevelError:
-		return "ERROR"
-	case level >= slog.LevelWarn:
-		return "WARN"
-	case level >= slog.LevelInfo:
-		return "INFO"
-	default:
-		return "DEBUG"
-	}
+	return levelName(level)
 }
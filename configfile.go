@@ -0,0 +1,417 @@
+package xlogging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the on-disk representation of a logging configuration.
+type fileConfig struct {
+	Env         string               `json:"env" yaml:"env"`
+	Level       *LevelFlag           `json:"level" yaml:"level"`
+	Levels      map[string]LevelFlag `json:"levels" yaml:"levels"`
+	Format      *string              `json:"format" yaml:"format"`
+	Outputs     []string             `json:"outputs" yaml:"outputs"`
+	ContextKeys []string             `json:"context_keys" yaml:"context_keys"`
+	Source      *bool                `json:"source" yaml:"source"`
+	Color       *bool                `json:"color" yaml:"color"`
+	Sampling    *fileSampling        `json:"sampling" yaml:"sampling"`
+	Redact      []string             `json:"redact" yaml:"redact"`
+}
+
+// fileSampling is the on-disk representation of Sampling.
+type fileSampling struct {
+	Tick       string `json:"tick" yaml:"tick"`
+	First      int    `json:"first" yaml:"first"`
+	Thereafter int    `json:"thereafter" yaml:"thereafter"`
+}
+
+// defaultWatchInterval is the polling interval of Watch for non-positive intervals.
+const defaultWatchInterval = time.Second
+
+// configSettings is a validated fileConfig.
+// Fields missing from the file are unset, nil or "", and read from the
+// environment variables of the logger.
+type configSettings struct {
+	env         Env
+	level       *Level
+	levels      map[string]Level
+	format      *Format
+	outputs     []string
+	contextKeys []ContextKey
+	addSource   *bool
+	useColor    *bool
+	sampling    *Sampling
+	redact      []string
+}
+
+// ConfigFile is a logging configuration loaded from a JSON or YAML file.
+//
+// Loggers created with its Option share the file's level, sampling and
+// redaction settings, so changes applied by Reload or Watch take effect
+// on them without a restart. Changes to the other settings require
+// creating new loggers.
+type ConfigFile struct {
+	path string
+
+	mu          sync.Mutex
+	env         Env     // "" if not set
+	format      *Format // nil if not set
+	output      io.Writer
+	closers     []io.Closer
+	contextKeys []ContextKey // nil if not set
+	addSource   *bool        // nil if not set
+	useColor    *bool        // nil if not set
+	level       *Level       // nil if not set
+	levels      map[string]Level
+	levelVars   map[levelVarKey]*slog.LevelVar
+	sampler     *sampler
+	redactor    *redactor
+}
+
+// LoadConfig loads a logging configuration from a file.
+// Files with a .yaml or .yml extension are parsed as YAML, all others as JSON.
+// Unknown fields are rejected.
+//
+// Example file:
+//
+//	env: production
+//	level: info
+//	levels:
+//	  db: debug
+//	format: json
+//	outputs: [stdout, /var/log/app.log]
+//	context_keys: [request_id, trace_id]
+//	source: true
+//	sampling:
+//	  tick: 1s
+//	  first: 100
+//	  thereafter: 10
+//	redact: [password, token]
+func LoadConfig(path string) (*ConfigFile, error) {
+	s, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cf := &ConfigFile{
+		path:        path,
+		env:         s.env,
+		format:      s.format,
+		contextKeys: s.contextKeys,
+		addSource:   s.addSource,
+		useColor:    s.useColor,
+		level:       s.level,
+		levels:      s.levels,
+		levelVars:   make(map[levelVarKey]*slog.LevelVar),
+		sampler:     newSampler(s.sampling),
+		redactor:    newRedactor(s.redact),
+	}
+
+	var writers multiOutput
+	for _, name := range s.outputs {
+		w, closer, err := openOutput(name)
+		if err != nil {
+			_ = cf.Close()
+			return nil, err
+		}
+		writers = append(writers, w)
+		if closer != nil {
+			cf.closers = append(cf.closers, closer)
+		}
+	}
+	switch len(writers) {
+	case 0:
+	case 1:
+		cf.output = writers[0]
+	default:
+		cf.output = writers
+	}
+
+	return cf, nil
+}
+
+// Option returns an Option that applies the configuration.
+// Options given after it override individual settings; WithLevel
+// detaches the logger from the file's reloadable levels. Settings the
+// file does not set, including those removed by Reload, are read from
+// the environment variables with the prefix set by WithEnvPrefix.
+func (cf *ConfigFile) Option() Option {
+	return func(c *config) {
+		cf.mu.Lock()
+		defer cf.mu.Unlock()
+
+		if cf.env != "" {
+			c.env = cf.env
+			c.override(envKeyEnv)
+		}
+		if cf.format != nil {
+			c.format = *cf.format
+			c.override(envKeyFormat)
+		}
+		if cf.output != nil {
+			c.output = cf.output
+			c.override(envKeyOutput)
+		}
+		if cf.contextKeys != nil {
+			c.contextKeys = cf.contextKeys
+			c.override(envKeyContextKeys)
+		}
+		if cf.addSource != nil {
+			c.addSource = *cf.addSource
+			c.override(envKeySource)
+		}
+		if cf.useColor != nil {
+			c.useColor = cf.useColor
+			c.override(envKeyColor)
+		}
+		c.levelProvider = func(name string) slog.Leveler {
+			return cf.levelVar(name, c)
+		}
+		// Shared for reloading; the sampling and redaction of the environment
+		// variables apply while the file does not set them
+		c.sampler = cf.sampler
+		c.redactor = cf.redactor
+	}
+}
+
+// defaultLevel returns the level of loggers created with c if the file does
+// not set one: the level read from the environment variables, or the default
+// level of the file's environment.
+// The caller must hold cf.mu.
+func (cf *ConfigFile) defaultLevel(c *config) Level {
+	if cf.env == "" {
+		return c.level
+	}
+	// Invalid values have been reported when the environment variables were read
+	level, _ := detectLevel(c.envPrefix, cf.env)
+	return level
+}
+
+// Reload re-reads the file and applies its level, sampling and redaction
+// settings to all loggers created from this configuration.
+// On error, the previous settings stay in effect.
+func (cf *ConfigFile) Reload() error {
+	s, err := readConfigFile(cf.path)
+	if err != nil {
+		return err
+	}
+
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	cf.level = s.level
+	cf.levels = s.levels
+	for key, v := range cf.levelVars {
+		v.Set(cf.levelFor(key.name, key.fallback))
+	}
+	cf.sampler.set(s.sampling)
+	cf.redactor.set(s.redact)
+	return nil
+}
+
+// Watch polls the file every interval, or every second if interval is not
+// positive, and calls Reload when its modification time or size changes.
+// Reload errors are passed to onError if it is not nil. The returned
+// function stops watching and waits until onError can no longer be called.
+func (cf *ConfigFile) Watch(interval time.Duration, onError func(error)) (stop func()) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	last, _ := os.Stat(cf.path)
+
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(cf.path)
+			if err != nil {
+				if onError != nil {
+					onError(err)
+				}
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+			if err := cf.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-exited
+	}
+}
+
+// Close closes the output files opened by the configuration.
+func (cf *ConfigFile) Close() error {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	var errs []error
+	for _, c := range cf.closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	cf.closers = nil
+	return errors.Join(errs...)
+}
+
+// levelVarKey identifies the loggers sharing a reloadable level: those with
+// the same name and the same level if the file does not set one.
+type levelVarKey struct {
+	name     string
+	fallback Level
+}
+
+// levelVar returns the reloadable level for loggers with the given name
+// created with c.
+func (cf *ConfigFile) levelVar(name string, c *config) slog.Leveler {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	key := levelVarKey{name: name, fallback: cf.defaultLevel(c)}
+	v, ok := cf.levelVars[key]
+	if !ok {
+		v = new(slog.LevelVar)
+		v.Set(cf.levelFor(key.name, key.fallback))
+		cf.levelVars[key] = v
+	}
+	return v
+}
+
+// levelFor returns the configured level for the given logger name,
+// or fallback if the file does not set one.
+// The caller must hold cf.mu.
+func (cf *ConfigFile) levelFor(name string, fallback Level) Level {
+	if level, ok := cf.levels[name]; ok && name != "" {
+		return level
+	}
+	if cf.level != nil {
+		return *cf.level
+	}
+	return fallback
+}
+
+// readConfigFile reads and validates a configuration file.
+// Empty files are valid and yield the defaults.
+func readConfigFile(path string) (*configSettings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("xlogging: read config: %w", err)
+	}
+
+	// A single document is allowed, nothing may follow it
+	var fc fileConfig
+	var rest any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(&fc); err == nil && !errors.Is(dec.Decode(&rest), io.EOF) {
+			err = errors.New("more than one document")
+		}
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(&fc); err == nil && !errors.Is(dec.Decode(&rest), io.EOF) {
+			err = errors.New("unexpected data after the configuration")
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("xlogging: parse config %s: %w", path, err)
+	}
+
+	s, err := fc.settings()
+	if err != nil {
+		return nil, fmt.Errorf("xlogging: config %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// settings validates the file configuration.
+func (fc *fileConfig) settings() (*configSettings, error) {
+	s := &configSettings{
+		outputs:   fc.Outputs,
+		addSource: fc.Source,
+		useColor:  fc.Color,
+		redact:    fc.Redact,
+	}
+	if fc.ContextKeys != nil {
+		s.contextKeys = make([]ContextKey, 0, len(fc.ContextKeys))
+	}
+
+	var err error
+	if fc.Env != "" {
+		if s.env, err = parseEnv(fc.Env); err != nil {
+			return nil, err
+		}
+	}
+
+	if fc.Level != nil {
+		level := fc.Level.Level()
+		s.level = &level
+	}
+
+	if len(fc.Levels) > 0 {
+		s.levels = make(map[string]Level, len(fc.Levels))
+		for name, level := range fc.Levels {
+			s.levels[name] = level.Level()
+		}
+	}
+
+	if fc.Format != nil {
+		format, err := parseFormat(*fc.Format)
+		if err != nil {
+			return nil, err
+		}
+		s.format = &format
+	}
+
+	for _, key := range fc.ContextKeys {
+		s.contextKeys = append(s.contextKeys, ContextKey(key))
+	}
+
+	if fc.Sampling != nil {
+		s.sampling = &Sampling{
+			First:      fc.Sampling.First,
+			Thereafter: fc.Sampling.Thereafter,
+		}
+		if fc.Sampling.Tick != "" {
+			if s.sampling.Tick, err = time.ParseDuration(fc.Sampling.Tick); err != nil {
+				return nil, fmt.Errorf("xlogging: invalid sampling tick: %w", err)
+			}
+		}
+	}
+
+	return s, nil
+}
@@ -1,6 +1,12 @@
 package xlogging
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+)
 
 // ContextKey is a type for context keys used by xlogging.
 type ContextKey string
@@ -13,6 +19,165 @@ const (
 	KeyUserID    ContextKey = "user_id"
 )
 
+// TypedContextKey is a context key whose values have type T. Values are stored
+// under the ContextKey of the same name, so they are extracted by WithContextKeys
+// like those of the predefined keys.
+type TypedContextKey[T any] struct {
+	key ContextKey
+}
+
+// NewContextKey creates a context key for values of type T.
+func NewContextKey[T any](name string) TypedContextKey[T] {
+	return TypedContextKey[T]{key: ContextKey(name)}
+}
+
+// Key returns the ContextKey under which values are stored, for use with WithContextKeys.
+func (k TypedContextKey[T]) Key() ContextKey {
+	return k.key
+}
+
+// With adds a value to the context.
+func (k TypedContextKey[T]) With(ctx context.Context, v T) context.Context {
+	return context.WithValue(ctx, k.key, v)
+}
+
+// Get retrieves the value from the context.
+func (k TypedContextKey[T]) Get(ctx context.Context) (T, bool) {
+	v, ok := ctx.Value(k.key).(T)
+	return v, ok
+}
+
+// attrsKey is the context key of the attributes added by AppendCtx.
+type attrsKey struct{}
+
+// AppendCtx returns a copy of ctx carrying the given attributes in addition to
+// those added before, so that they are added to every record logged with the
+// returned context. Arguments are key-value pairs or slog.Attr values, as for
+// Logger.Info.
+func AppendCtx(ctx context.Context, args ...any) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	attrs := slog.Group("", args...).Value.Group()
+	if len(attrs) == 0 {
+		return ctx
+	}
+	prev := ctxAttrs(ctx)
+	return context.WithValue(ctx, attrsKey{}, append(prev[:len(prev):len(prev)], attrs...))
+}
+
+// ctxAttrs returns the attributes added to ctx by AppendCtx.
+func ctxAttrs(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// levelOverrideKey is the context key of the level set by WithLevelOverride.
+type levelOverrideKey struct{}
+
+// WithLevelOverride returns a copy of ctx that replaces the minimum level of
+// the logger for the records logged with it, e.g. to log debug records of a
+// single request in production. Sampling and redaction still apply.
+func WithLevelOverride(ctx context.Context, level Level) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, levelOverrideKey{}, level)
+}
+
+// levelOverride returns the level set by WithLevelOverride, tolerating a nil context.
+func levelOverride(ctx context.Context) (Level, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	level, ok := ctx.Value(levelOverrideKey{}).(Level)
+	return level, ok
+}
+
+// loggerKey is the context key of the Logger added by IntoContext.
+type loggerKey struct{}
+
+// IntoContext returns a copy of ctx carrying the given Logger, to be retrieved with FromContext.
+func IntoContext(ctx context.Context, l Logger) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the Logger added to ctx by IntoContext, or the fallback
+// Logger set by SetFallback if there is none. The returned Logger is bound to
+// ctx, so that its methods without a context argument extract the configured
+// context keys, such as KeyRequestID, from ctx. It never returns nil.
+func FromContext(ctx context.Context) Logger {
+	if ctx == nil {
+		return fallbackLogger()
+	}
+	l, ok := ctx.Value(loggerKey{}).(Logger)
+	if !ok || l == nil {
+		l = fallbackLogger()
+	}
+	return l.WithContext(ctx)
+}
+
+// fallback holds the Logger returned by FromContext for contexts without one.
+var fallback struct {
+	sync.RWMutex
+	logger Logger
+}
+
+// SetFallback sets the Logger returned by FromContext for contexts without one.
+// Until it is called, or after it is called with nil, that is a Logger created by Default.
+func SetFallback(l Logger) {
+	fallback.Lock()
+	defer fallback.Unlock()
+	fallback.logger = l
+}
+
+// fallbackLogger returns the fallback Logger, creating the default one on first use.
+func fallbackLogger() Logger {
+	fallback.RLock()
+	l := fallback.logger
+	fallback.RUnlock()
+	if l != nil {
+		return l
+	}
+
+	fallback.Lock()
+	defer fallback.Unlock()
+	if fallback.logger == nil {
+		fallback.logger = Default()
+	}
+	return fallback.logger
+}
+
+// contextValue returns the value of key in ctx as an attribute value, resolving
+// slog.LogValuer and rendering fmt.Stringer as a string. It reports false if
+// the context is nil or holds no value, a nil pointer or an empty string for key.
+func contextValue(ctx context.Context, key ContextKey) (slog.Value, bool) {
+	if ctx == nil {
+		return slog.Value{}, false
+	}
+	x := ctx.Value(key)
+	if x == nil {
+		return slog.Value{}, false
+	}
+	if rv := reflect.ValueOf(x); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return slog.Value{}, false
+	}
+
+	v := slog.AnyValue(x).Resolve()
+	if v.Kind() == slog.KindAny {
+		if s, ok := v.Any().(fmt.Stringer); ok {
+			v = slog.StringValue(s.String())
+		}
+	}
+	if v.Kind() == slog.KindString && v.String() == "" {
+		return slog.Value{}, false
+	}
+	return v, true
+}
+
 // WithRequestID adds a request ID to the context.
 func WithRequestID(ctx context.Context, requestID string) context.Context {
 	return context.WithValue(ctx, KeyRequestID, requestID)
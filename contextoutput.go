@@ -0,0 +1,154 @@
+package xlogging
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ContextConflict selects what happens when an attribute derived from the
+// context has the same key as an attribute of the record.
+type ContextConflict int
+
+// Context conflict policies.
+const (
+	// ContextConflictKeepBoth emits both attributes.
+	ContextConflictKeepBoth ContextConflict = iota
+	// ContextConflictPreferRecord drops the context attribute.
+	ContextConflictPreferRecord
+	// ContextConflictPreferContext drops the record attribute. Attributes added
+	// with Logger.With have already been passed to the handler and are kept.
+	ContextConflictPreferContext
+)
+
+// ContextOutput configures how values derived from the context are emitted:
+// those of WithContextKeys, AppendCtx and WithContextExtractor.
+type ContextOutput struct {
+	// Names maps context keys to the attribute keys they are emitted as,
+	// e.g. KeyTraceID to "logging.googleapis.com/trace". Unmapped keys are
+	// emitted under their own name.
+	Names map[ContextKey]string
+	// Group nests all context-derived attributes in a group of this name.
+	// Console output renders the values of context keys in their usual
+	// position, prefixed with the group name.
+	Group string
+	// Conflict selects what happens when a context-derived attribute has the
+	// same key as an attribute of the record at the same level.
+	Conflict ContextConflict
+}
+
+// parseContextOutput parses context output settings in the form
+// "trace_id=trace.id,group=ctx,conflict=record". Fields other than group and
+// conflict map a context key to its output name; conflict is one of both,
+// record or context.
+func parseContextOutput(s string) (*ContextOutput, error) {
+	var o ContextOutput
+	for _, field := range splitList(s) {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("xlogging: invalid context output field %q", field)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		switch strings.ToLower(key) {
+		case "group":
+			o.Group = val
+		case "conflict":
+			switch strings.ToLower(val) {
+			case "both":
+				o.Conflict = ContextConflictKeepBoth
+			case "record":
+				o.Conflict = ContextConflictPreferRecord
+			case "context":
+				o.Conflict = ContextConflictPreferContext
+			default:
+				return nil, fmt.Errorf("xlogging: unknown context conflict policy %q", val)
+			}
+		default:
+			if o.Names == nil {
+				o.Names = make(map[ContextKey]string)
+			}
+			o.Names[ContextKey(key)] = val
+		}
+	}
+	return &o, nil
+}
+
+// name returns the attribute key of the values of a context key.
+func (o *ContextOutput) name(key ContextKey) string {
+	if o != nil {
+		if name, ok := o.Names[key]; ok && name != "" {
+			return name
+		}
+	}
+	return string(key)
+}
+
+// group returns the group name of context-derived attributes, or "".
+func (o *ContextOutput) group() string {
+	if o == nil {
+		return ""
+	}
+	return o.Group
+}
+
+// conflict returns the conflict policy.
+func (o *ContextOutput) conflict() ContextConflict {
+	if o == nil {
+		return ContextConflictKeepBoth
+	}
+	return o.Conflict
+}
+
+// keySet is a set of attribute keys.
+type keySet map[string]struct{}
+
+// has reports whether the set contains key. A nil set contains nothing.
+func (s keySet) has(key string) bool {
+	_, ok := s[key]
+	return ok
+}
+
+// recordKeys returns the keys of the attributes of r and of preset, the keys
+// added with WithAttrs at the level where r's attributes are emitted.
+func recordKeys(r slog.Record, preset []string) keySet {
+	keys := make(keySet, r.NumAttrs()+len(preset))
+	for _, key := range preset {
+		keys[key] = struct{}{}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		keys[a.Key] = struct{}{}
+		return true
+	})
+	return keys
+}
+
+// attrKeys returns the keys of attrs.
+func attrKeys(attrs []slog.Attr) keySet {
+	keys := make(keySet, len(attrs))
+	for _, a := range attrs {
+		keys[a.Key] = struct{}{}
+	}
+	return keys
+}
+
+// withoutKeys returns a copy of r without the attributes whose key is in drop.
+func withoutKeys(r slog.Record, drop keySet) slog.Record {
+	kept := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		if !drop.has(a.Key) {
+			kept.AddAttrs(a)
+		}
+		return true
+	})
+	return kept
+}
+
+// appendPresetKeys returns the keys added with WithAttrs at the current level
+// after adding attrs, for detecting conflicts.
+func appendPresetKeys(preset []string, attrs []slog.Attr) []string {
+	preset = preset[:len(preset):len(preset)]
+	for _, a := range attrs {
+		preset = append(preset, a.Key)
+	}
+	return preset
+}
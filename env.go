@@ -1,7 +1,9 @@
 package xlogging
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"golang.org/x/term"
@@ -17,35 +19,238 @@ const (
 	EnvDevelopment Env = "development"
 )
 
-// Environment variable names.
+// Environment variable names, relative to the prefix set by WithEnvPrefix.
 const (
-	envKeyEnv   = "XLOG_ENV"
-	envKeyLevel = "XLOG_LEVEL"
+	defaultEnvPrefix  = "XLOG_"
+	envKeyEnv         = "ENV"
+	envKeyLevel       = "LEVEL"
+	envKeyFormat      = "FORMAT"
+	envKeyOutput      = "OUTPUT"
+	envKeySource      = "SOURCE"
+	envKeyColor       = "COLOR"
+	envKeyContextKeys = "CONTEXT_KEYS"
+	envKeyContextOut  = "CONTEXT_OUTPUT"
+	envKeySampling    = "SAMPLING"
+	envKeyRedact      = "REDACT"
+	envKeyTheme       = "THEME"
+	envKeyExpanded    = "EXPANDED"
+	envKeyTimeMode    = "TIME_MODE"
+	envKeyLayout      = "LAYOUT"
+	envKeyReqColors   = "REQUEST_COLORS"
+	envKeySourceLinks = "SOURCE_LINKS"
+	envKeySanitize    = "SANITIZE"
+	envKeyFlightRec   = "FLIGHT_RECORDER"
 )
 
-// detectEnv reads XLOG_ENV and returns the corresponding Env.
-// Defaults to EnvDevelopment if not set or unrecognized.
-func detectEnv() Env {
-	val := strings.ToLower(strings.TrimSpace(os.Getenv(envKeyEnv)))
-	switch val {
+// getEnv returns the trimmed value of the environment variable prefix+key.
+func getEnv(prefix, key string) string {
+	return strings.TrimSpace(os.Getenv(prefix + key))
+}
+
+// detectEnv reads the ENV variable with the given prefix and returns the corresponding Env.
+// Defaults to EnvDevelopment if not set. If set to an invalid value,
+// returns EnvDevelopment and an error describing the value.
+func detectEnv(prefix string) (Env, error) {
+	env, err := parseEnv(getEnv(prefix, envKeyEnv))
+	if err != nil {
+		return EnvDevelopment, fmt.Errorf("%s%s: %w", prefix, envKeyEnv, err)
+	}
+	return env, nil
+}
+
+// parseEnv parses an environment name (case-insensitive, short forms allowed).
+// An empty string yields EnvDevelopment.
+func parseEnv(s string) (Env, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
 	case "production", "prod":
-		return EnvProduction
+		return EnvProduction, nil
 	case "staging", "stage":
-		return EnvStaging
-	case "development", "dev":
-		return EnvDevelopment
+		return EnvStaging, nil
+	case "development", "dev", "":
+		return EnvDevelopment, nil
 	default:
-		return EnvDevelopment
+		return EnvDevelopment, fmt.Errorf("xlogging: unknown environment %q", s)
 	}
 }
 
-// detectLevel reads XLOG_LEVEL and returns the corresponding Level.
+// detectLevel reads the LEVEL variable with the given prefix and returns the corresponding Level.
 // If not set, returns the default level for the given environment.
-func detectLevel(env Env) Level {
-	if val := os.Getenv(envKeyLevel); val != "" {
-		return ParseLevel(val)
+// If set to an invalid value, returns the default level and an error describing the value.
+func detectLevel(prefix string, env Env) (Level, error) {
+	if val := getEnv(prefix, envKeyLevel); val != "" {
+		level, err := ParseLevelStrict(val)
+		if err != nil {
+			return defaultLevelForEnv(env), fmt.Errorf("%s%s: %w", prefix, envKeyLevel, err)
+		}
+		return level, nil
 	}
-	return defaultLevelForEnv(env)
+	return defaultLevelForEnv(env), nil
+}
+
+// loadEnv applies the environment variables with the given prefix to c,
+// except those overridden by options. Invalid values are recorded as
+// warnings and leave the defaults in place.
+func (c *config) loadEnv(prefix string) {
+	warn := func(err error) {
+		c.warnings = append(c.warnings, err)
+	}
+	invalid := func(key string, err error) {
+		warn(fmt.Errorf("%s%s: %w", prefix, key, err))
+	}
+	// Values are validated even if overridden, so that mistakes are reported
+	apply := func(key string) bool {
+		return !c.overridden.has(key)
+	}
+
+	env, err := detectEnv(prefix)
+	if err != nil {
+		warn(err)
+	}
+	if apply(envKeyEnv) {
+		c.env = env
+	}
+
+	level, err := detectLevel(prefix, env)
+	if err != nil {
+		warn(err)
+	}
+	if apply(envKeyLevel) {
+		c.level = level
+	}
+
+	if val := getEnv(prefix, envKeyFormat); val != "" {
+		if format, err := parseFormat(val); err != nil {
+			invalid(envKeyFormat, err)
+		} else if apply(envKeyFormat) {
+			c.format = format
+		}
+	}
+
+	// Output files stay open, so they are only opened if used
+	if val := getEnv(prefix, envKeyOutput); val != "" && apply(envKeyOutput) {
+		if w, err := openEnvOutput(val); err != nil {
+			invalid(envKeyOutput, err)
+		} else {
+			c.output = w
+		}
+	}
+
+	if val := getEnv(prefix, envKeySource); val != "" {
+		if enabled, err := strconv.ParseBool(val); err != nil {
+			invalid(envKeySource, fmt.Errorf("xlogging: invalid boolean %q", val))
+		} else if apply(envKeySource) {
+			c.addSource = enabled
+		}
+	}
+
+	if val := getEnv(prefix, envKeySourceLinks); val != "" {
+		if links, err := parseSourceLinks(val); err != nil {
+			invalid(envKeySourceLinks, err)
+		} else if apply(envKeySourceLinks) {
+			c.sourceLinks = links
+		}
+	}
+
+	if val := getEnv(prefix, envKeyColor); val != "" && !strings.EqualFold(val, "auto") {
+		if enabled, err := strconv.ParseBool(val); err != nil {
+			invalid(envKeyColor, fmt.Errorf("xlogging: invalid boolean %q", val))
+		} else if apply(envKeyColor) {
+			c.useColor = &enabled
+		}
+	}
+
+	if val := getEnv(prefix, envKeyContextKeys); val != "" && apply(envKeyContextKeys) {
+		for _, key := range splitList(val) {
+			c.contextKeys = append(c.contextKeys, ContextKey(key))
+		}
+	}
+
+	if val := getEnv(prefix, envKeyContextOut); val != "" {
+		if o, err := parseContextOutput(val); err != nil {
+			invalid(envKeyContextOut, err)
+		} else if apply(envKeyContextOut) {
+			c.contextOutput = o
+		}
+	}
+
+	if val := getEnv(prefix, envKeySampling); val != "" {
+		if sampling, err := parseSampling(val); err != nil {
+			invalid(envKeySampling, err)
+		} else if apply(envKeySampling) {
+			c.sampling = sampling
+		}
+	}
+
+	if val := getEnv(prefix, envKeyFlightRec); val != "" {
+		if fr, err := parseFlightRecorder(val); err != nil {
+			invalid(envKeyFlightRec, err)
+		} else if apply(envKeyFlightRec) {
+			c.flight = fr
+		}
+	}
+
+	if val := getEnv(prefix, envKeyRedact); val != "" && apply(envKeyRedact) {
+		c.redactKeys = splitList(val)
+	}
+
+	if val := getEnv(prefix, envKeyExpanded); val != "" {
+		if enabled, err := strconv.ParseBool(val); err != nil {
+			invalid(envKeyExpanded, fmt.Errorf("xlogging: invalid boolean %q", val))
+		} else if apply(envKeyExpanded) {
+			c.expanded = enabled
+		}
+	}
+
+	if val := getEnv(prefix, envKeyTimeMode); val != "" {
+		if mode, err := parseTimeMode(val); err != nil {
+			invalid(envKeyTimeMode, err)
+		} else if apply(envKeyTimeMode) {
+			c.timeMode = mode
+		}
+	}
+
+	if val := getEnv(prefix, envKeyLayout); val != "" {
+		if layout, err := parseLayout(val); err != nil {
+			invalid(envKeyLayout, err)
+		} else if apply(envKeyLayout) {
+			c.layout = layout
+		}
+	}
+
+	if val := getEnv(prefix, envKeyReqColors); val != "" {
+		if rc, err := parseRequestColors(val); err != nil {
+			invalid(envKeyReqColors, err)
+		} else if apply(envKeyReqColors) {
+			c.requestColors = rc
+		}
+	}
+
+	if val := getEnv(prefix, envKeySanitize); val != "" {
+		if enabled, err := strconv.ParseBool(val); err != nil {
+			invalid(envKeySanitize, fmt.Errorf("xlogging: invalid boolean %q", val))
+		} else if apply(envKeySanitize) {
+			c.verbatim = !enabled
+		}
+	}
+
+	if val := getEnv(prefix, envKeyTheme); val != "" {
+		if theme, err := parseTheme(val); err != nil {
+			invalid(envKeyTheme, err)
+		} else if apply(envKeyTheme) {
+			c.theme = &theme
+		}
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty elements.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 // defaultLevelForEnv returns the default log level for the given environment.
@@ -64,13 +269,3 @@ func defaultLevelForEnv(env Env) Level {
 func isTerminal(fd int) bool {
 	return term.IsTerminal(fd)
 }
-
-// isStdoutTerminal checks if stdout is a terminal.
-func isStdoutTerminal() bool {
-	return isTerminal(int(os.Stdout.Fd()))
-}
-
-// isStderrTerminal checks if stderr is a terminal.
-func isStderrTerminal() bool {
-	return isTerminal(int(os.Stderr.Fd()))
-}
@@ -0,0 +1,298 @@
+package xlogging
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits of the expanded console mode.
+const (
+	expandMaxDepth = 5  // nesting levels rendered before values are elided
+	expandMaxItems = 20 // elements rendered per struct, map, slice or array
+	expandMaxLines = 50 // lines rendered per multi-line string or JSON document
+)
+
+// Indentation of blocks rendered below a record in expanded mode.
+const (
+	blockKeyIndent   = "  "
+	blockValueIndent = "    "
+)
+
+// needsQuoting reports whether a console value must be quoted to keep the
+// line parseable as key=value pairs.
+func needsQuoting(s []byte) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			if b <= ' ' || b == '=' || b == '"' || b == 0x7f {
+				return true
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// quoteFrom quotes buf[start:] in place if it needs quoting.
+func quoteFrom(buf []byte, start int) []byte {
+	if !needsQuoting(buf[start:]) {
+		return buf
+	}
+	s := string(buf[start:])
+	return strconv.AppendQuote(buf[:start], s)
+}
+
+// expandable reports whether a value is rendered as a block below the record in expanded mode.
+func expandable(v slog.Value) bool {
+	switch v.Kind() {
+	case slog.KindString:
+		return strings.Contains(v.String(), "\n")
+	case slog.KindAny:
+	default:
+		return false
+	}
+
+	switch x := v.Any().(type) {
+	case json.RawMessage:
+		return isJSONDocument(x)
+	case []byte:
+		return isJSONDocument(x)
+	case error, fmt.Stringer, encoding.TextMarshaler:
+		return false
+	}
+	return isComposite(reflect.ValueOf(v.Any()))
+}
+
+// isJSONDocument reports whether b holds a JSON object or array.
+func isJSONDocument(b []byte) bool {
+	trimmed := bytes.TrimSpace(b)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed)
+}
+
+// isComposite reports whether rv, after dereferencing pointers and interfaces,
+// is a non-empty struct, map, slice or array.
+func isComposite(rv reflect.Value) bool {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		return rv.NumField() > 0
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return rv.Len() > 0
+	default:
+		return false
+	}
+}
+
+// appendBlock renders an expandable value as an indented block below the record:
+// the key on its own line followed by the value, one line per element.
+func (h *colorHandler) appendBlock(buf []byte, keyColor, key string, v slog.Value) []byte {
+	p := h.palette
+	buf = append(buf, blockKeyIndent...)
+	buf = append(buf, keyColor...)
+	buf = append(buf, key...)
+	buf = append(buf, colorReset...)
+	buf = append(buf, ":\n"...)
+
+	switch x := v.Any().(type) {
+	case string:
+		return h.appendLines(buf, p.str, x)
+	case json.RawMessage:
+		return h.appendJSON(buf, x)
+	case []byte:
+		return h.appendJSON(buf, x)
+	default:
+		return h.appendTree(buf, reflect.ValueOf(x), blockValueIndent, 0)
+	}
+}
+
+// appendLines renders each line of s indented below the record, up to expandMaxLines.
+func (h *colorHandler) appendLines(buf []byte, color, s string) []byte {
+	s = strings.TrimSuffix(s, "\n")
+	for i := 0; s != "" || i == 0; i++ {
+		line, rest, _ := strings.Cut(s, "\n")
+		if i == expandMaxLines {
+			buf = append(buf, blockValueIndent...)
+			buf = append(buf, "... ("...)
+			buf = strconv.AppendInt(buf, int64(strings.Count(s, "\n")+1), 10)
+			return append(buf, " more lines)\n"...)
+		}
+		buf = append(buf, blockValueIndent...)
+		buf = append(buf, color...)
+		buf = h.appendText(buf, line)
+		buf = append(buf, colorReset...)
+		buf = append(buf, '\n')
+		s = rest
+	}
+	return buf
+}
+
+// appendJSON renders a JSON document indented below the record.
+func (h *colorHandler) appendJSON(buf []byte, doc []byte) []byte {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, bytes.TrimSpace(doc), "", "  "); err != nil {
+		return h.appendLines(buf, h.palette.anyValue, string(doc))
+	}
+	return h.appendLines(buf, h.palette.str, indented.String())
+}
+
+// appendTree renders a composite value as an indented tree, one element per line.
+// Scalars are rendered on the line of their key; composite elements start a
+// new level of indentation.
+func (h *colorHandler) appendTree(buf []byte, rv reflect.Value, indent string, depth int) []byte {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			break
+		}
+		rv = rv.Elem()
+	}
+
+	p := h.palette
+	child := indent + "  "
+	elem := func(buf []byte, label string, labelColor string, v reflect.Value) []byte {
+		buf = append(buf, indent...)
+		buf = append(buf, labelColor...)
+		buf = append(buf, label...)
+		buf = append(buf, colorReset...)
+		if isComposite(v) && !isScalarType(v) {
+			if depth+1 >= expandMaxDepth {
+				buf = append(buf, ' ')
+				buf = append(buf, p.anyValue...)
+				buf = append(buf, "..."...)
+				buf = append(buf, colorReset...)
+				return append(buf, '\n')
+			}
+			buf = append(buf, '\n')
+			return h.appendTree(buf, v, child, depth+1)
+		}
+		buf = append(buf, ' ')
+		buf = h.appendScalar(buf, v)
+		return append(buf, '\n')
+	}
+	more := func(buf []byte, n int) []byte {
+		buf = append(buf, indent...)
+		buf = append(buf, "... ("...)
+		buf = strconv.AppendInt(buf, int64(n), 10)
+		return append(buf, " more)\n"...)
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		t := rv.Type()
+		shown := 0
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if shown == expandMaxItems {
+				return more(buf, exportedFields(t, i))
+			}
+			buf = elem(buf, field.Name+":", p.key, rv.Field(i))
+			shown++
+		}
+		return buf
+	case reflect.Map:
+		keys := rv.MapKeys()
+		labels := make([]string, len(keys))
+		for i, k := range keys {
+			labels[i] = fmt.Sprint(k.Interface())
+		}
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortFunc(order, func(a, b int) int { return strings.Compare(labels[a], labels[b]) })
+		for n, i := range order {
+			if n == expandMaxItems {
+				return more(buf, len(keys)-n)
+			}
+			buf = elem(buf, h.text(labels[i])+":", p.key, rv.MapIndex(keys[i]))
+		}
+		return buf
+	case reflect.Slice, reflect.Array:
+		for i := range rv.Len() {
+			if i == expandMaxItems {
+				return more(buf, rv.Len()-i)
+			}
+			buf = elem(buf, "-", p.anyValue, rv.Index(i))
+		}
+		return buf
+	default:
+		buf = append(buf, indent...)
+		buf = h.appendScalar(buf, rv)
+		return append(buf, '\n')
+	}
+}
+
+// isScalarType reports whether a value of rv's type is rendered as a scalar
+// even though it may be a struct, e.g. time.Time or types implementing fmt.Stringer.
+func isScalarType(rv reflect.Value) bool {
+	if !rv.IsValid() || !rv.CanInterface() {
+		return false
+	}
+	switch rv.Interface().(type) {
+	case time.Time, error, fmt.Stringer, encoding.TextMarshaler:
+		return true
+	}
+	return false
+}
+
+// appendScalar renders a leaf of a tree with the color of its kind.
+func (h *colorHandler) appendScalar(buf []byte, rv reflect.Value) []byte {
+	var v slog.Value
+	switch {
+	case !rv.IsValid():
+		v = slog.AnyValue(nil)
+	case (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface || rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.IsNil():
+		v = slog.AnyValue(nil)
+	case !rv.CanInterface():
+		// Unexported values are reachable only through exported composites; render them plainly.
+		buf = append(buf, h.palette.anyValue...)
+		buf = fmt.Append(buf, rv)
+		return append(buf, colorReset...)
+	default:
+		v = slog.AnyValue(rv.Interface())
+	}
+
+	buf = append(buf, h.palette.value(v)...)
+	start := len(buf)
+	buf = h.appendValue(buf, v)
+	buf = quoteFrom(buf, start)
+	return append(buf, colorReset...)
+}
+
+// exportedFields returns the number of exported fields of the struct type t
+// from index i on.
+func exportedFields(t reflect.Type, i int) int {
+	n := 0
+	for ; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,241 @@
+package xlogging
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Defaults of the flight recorder.
+const (
+	defaultFlightRecords  = 100
+	defaultFlightRequests = 1000
+	defaultFlightLevel    = LevelDebug
+)
+
+// FlightRecorder configures the flight recorder enabled by WithFlightRecorder.
+// It keeps the last records below the configured level, which are otherwise
+// dropped, in memory and logs them before the next record at LevelError or
+// above, so that failures come with the debug detail that led up to them.
+// Records are buffered per request, by the value of KeyRequestID in the
+// context; records without one share a global buffer. Buffered records are
+// logged with the context of the error, so their context values are those of
+// the error.
+//
+// At most Records × Requests records are kept, 100,000 with the defaults;
+// each holds its attributes and those added with Logger.With.
+type FlightRecorder struct {
+	// Records is the number of records kept per request. Defaults to 100.
+	Records int
+	// Requests is the number of requests whose records are kept; the buffers
+	// of the least recently active requests are discarded. Defaults to 1000.
+	Requests int
+	// Level is the lowest level of the records kept; records below it are
+	// dropped as usual. Defaults to LevelDebug.
+	Level slog.Leveler
+}
+
+// parseFlightRecorder parses flight recorder settings in the form
+// "records=100,requests=1000,level=debug". The values "on" and "true" enable the defaults;
+// "off", "false" and "none" disable the flight recorder and yield nil.
+func parseFlightRecorder(s string) (*FlightRecorder, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "off", "false", "none":
+		return nil, nil
+	}
+
+	var fr FlightRecorder
+	for _, field := range splitList(s) {
+		switch strings.ToLower(field) {
+		case "on", "true":
+			continue
+		}
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("xlogging: invalid flight recorder field %q", field)
+		}
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "records":
+			fr.Records, err = strconv.Atoi(strings.TrimSpace(val))
+		case "requests":
+			fr.Requests, err = strconv.Atoi(strings.TrimSpace(val))
+		case "level":
+			fr.Level, err = ParseLevelStrict(strings.TrimSpace(val))
+		default:
+			return nil, fmt.Errorf("xlogging: unknown flight recorder field %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xlogging: invalid flight recorder %s: %w", key, err)
+		}
+	}
+	return &fr, nil
+}
+
+// flightEntry is a buffered record with the handler it was logged with.
+// The context is not kept, so that buffers do not retain request state.
+type flightEntry struct {
+	handler slog.Handler
+	record  slog.Record
+}
+
+// flightBuffer is the ring buffer of the records of one request.
+type flightBuffer struct {
+	id      string
+	entries []flightEntry
+	next    int // index of the oldest entry once the buffer is full
+	elem    *list.Element
+}
+
+// add adds an entry, overwriting the oldest one if the buffer is full.
+func (b *flightBuffer) add(e flightEntry, size int) {
+	if len(b.entries) < size {
+		b.entries = append(b.entries, e)
+		return
+	}
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % size
+}
+
+// drain returns the entries from oldest to newest.
+func (b *flightBuffer) drain() []flightEntry {
+	return append(b.entries[b.next:len(b.entries):len(b.entries)], b.entries[:b.next]...)
+}
+
+// flightRecorder holds the buffers of the requests, evicting the least recently used.
+type flightRecorder struct {
+	records  int
+	requests int
+	level    slog.Leveler
+
+	mu      sync.Mutex
+	buffers map[string]*flightBuffer
+	lru     list.List // of *flightBuffer, most recently used first
+}
+
+// newFlightRecorder creates a flight recorder for the given settings.
+func newFlightRecorder(fr FlightRecorder) *flightRecorder {
+	r := &flightRecorder{
+		records:  fr.Records,
+		requests: fr.Requests,
+		level:    fr.Level,
+		buffers:  make(map[string]*flightBuffer),
+	}
+	if r.records <= 0 {
+		r.records = defaultFlightRecords
+	}
+	if r.requests <= 0 {
+		r.requests = defaultFlightRequests
+	}
+	if r.level == nil {
+		r.level = defaultFlightLevel
+	}
+	return r
+}
+
+// requestKey returns the buffer key of a context: its request ID, or "" for the global buffer.
+func requestKey(ctx context.Context) string {
+	if v, ok := contextValue(ctx, KeyRequestID); ok {
+		return v.String()
+	}
+	return ""
+}
+
+// record buffers an entry for the request with the given ID.
+func (r *flightRecorder) record(id string, e flightEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.buffers[id]
+	if ok {
+		r.lru.MoveToFront(b.elem)
+	} else {
+		if len(r.buffers) == r.requests {
+			oldest := r.lru.Remove(r.lru.Back()).(*flightBuffer)
+			delete(r.buffers, oldest.id)
+		}
+		b = &flightBuffer{id: id}
+		b.elem = r.lru.PushFront(b)
+		r.buffers[id] = b
+	}
+	b.add(e, r.records)
+}
+
+// take removes and returns the entries of the request with the given ID, from oldest to newest.
+func (r *flightRecorder) take(id string) []flightEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.buffers[id]
+	if !ok {
+		return nil
+	}
+	r.lru.Remove(b.elem)
+	delete(r.buffers, id)
+	return b.drain()
+}
+
+// flightRecorderHandler wraps a slog.Handler to buffer the records it does not
+// handle and to handle them before the next error of the same request.
+type flightRecorderHandler struct {
+	inner    slog.Handler
+	recorder *flightRecorder
+}
+
+// newFlightRecorderHandler creates a new flightRecorderHandler wrapping the given handler.
+func newFlightRecorderHandler(inner slog.Handler, r *flightRecorder) *flightRecorderHandler {
+	return &flightRecorderHandler{
+		inner:    inner,
+		recorder: r,
+	}
+}
+
+// Enabled reports whether the wrapped handler handles the level or records
+// of the level are buffered.
+func (h *flightRecorderHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.recorder.level.Level() || h.inner.Enabled(ctx, level)
+}
+
+// Handle buffers the record if the wrapped handler does not handle it.
+// Before an error, the buffered records of its request are handled first,
+// with the context of the error.
+func (h *flightRecorderHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.inner.Enabled(ctx, r.Level) {
+		if r.Level >= h.recorder.level.Level() {
+			h.recorder.record(requestKey(ctx), flightEntry{handler: h.inner, record: r.Clone()})
+		}
+		return nil
+	}
+	if r.Level < LevelError {
+		return h.inner.Handle(ctx, r)
+	}
+
+	var errs []error
+	for _, e := range h.recorder.take(requestKey(ctx)) {
+		errs = append(errs, e.handler.Handle(ctx, e.record))
+	}
+	errs = append(errs, h.inner.Handle(ctx, r))
+	return errors.Join(errs...)
+}
+
+// WithAttrs returns a new handler with the given attributes.
+func (h *flightRecorderHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &flightRecorderHandler{
+		inner:    h.inner.WithAttrs(attrs),
+		recorder: h.recorder,
+	}
+}
+
+// WithGroup returns a new handler with the given group name.
+func (h *flightRecorderHandler) WithGroup(name string) slog.Handler {
+	return &flightRecorderHandler{
+		inner:    h.inner.WithGroup(name),
+		recorder: h.recorder,
+	}
+}
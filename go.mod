@@ -2,6 +2,9 @@ module github.com/iota101/xlogging
 
 go 1.25
 
-require golang.org/x/term v0.28.0
+require (
+	golang.org/x/term v0.28.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require golang.org/x/sys v0.29.0 // indirect
@@ -3,58 +3,96 @@ package xlogging
 import (
 	"context"
 	"log/slog"
+	"slices"
 )
 
-// contextHandler wraps a slog.Handler to extract values from context.
+// ContextExtractor turns data carried by a context into attributes of the
+// records logged with it. It is called for every record logged with a context
+// and should return nil quickly when the context holds nothing relevant.
+type ContextExtractor func(ctx context.Context) []slog.Attr
+
+// contextHandler wraps a slog.Handler to extract values from context: those of
+// the configured keys, the attributes added by AppendCtx and those of the extractors.
 type contextHandler struct {
 	inner       slog.Handler
 	contextKeys []ContextKey
+	extractors  []ContextExtractor
+	output      *ContextOutput
+	preset      []string // keys added with WithAttrs since the last group, for conflicts
 }
 
 // newContextHandler creates a new contextHandler wrapping the given handler.
-func newContextHandler(inner slog.Handler, keys []ContextKey) *contextHandler {
+func newContextHandler(inner slog.Handler, keys []ContextKey, extractors []ContextExtractor, output *ContextOutput) *contextHandler {
 	return &contextHandler{
 		inner:       inner,
 		contextKeys: keys,
+		extractors:  extractors,
+		output:      output,
 	}
 }
 
-// Enabled reports whether the handler handles records at the given level.
+// Enabled reports whether the handler handles records at the given level,
+// which the context may override with WithLevelOverride.
 func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if override, ok := levelOverride(ctx); ok {
+		return level >= override
+	}
 	return h.inner.Enabled(ctx, level)
 }
 
 // Handle handles the record, extracting context values and adding them as attributes.
 func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
-	if ctx != nil && len(h.contextKeys) > 0 {
-		attrs := make([]slog.Attr, 0, len(h.contextKeys))
-		for _, key := range h.contextKeys {
-			if v := ctx.Value(key); v != nil {
-				if s, ok := v.(string); ok && s != "" {
-					attrs = append(attrs, slog.String(string(key), s))
-				}
-			}
-		}
-		if len(attrs) > 0 {
-			r = r.Clone()
-			r.AddAttrs(attrs...)
+	if ctx == nil {
+		return h.inner.Handle(ctx, r)
+	}
+
+	var attrs []slog.Attr
+	for _, key := range h.contextKeys {
+		if v, ok := contextValue(ctx, key); ok {
+			attrs = append(attrs, slog.Attr{Key: h.output.name(key), Value: v})
 		}
 	}
+	attrs = append(attrs, ctxAttrs(ctx)...)
+	for _, extract := range h.extractors {
+		attrs = append(attrs, extract(ctx)...)
+	}
+	if len(attrs) == 0 {
+		return h.inner.Handle(ctx, r)
+	}
+
+	if group := h.output.group(); group != "" {
+		attrs = []slog.Attr{{Key: group, Value: slog.GroupValue(attrs...)}}
+	}
+	switch h.output.conflict() {
+	case ContextConflictPreferRecord:
+		keys := recordKeys(r, h.preset)
+		attrs = slices.DeleteFunc(slices.Clip(attrs), func(a slog.Attr) bool { return keys.has(a.Key) })
+		r = r.Clone()
+	case ContextConflictPreferContext:
+		r = withoutKeys(r, attrKeys(attrs))
+	default:
+		r = r.Clone()
+	}
+	r.AddAttrs(attrs...)
 	return h.inner.Handle(ctx, r)
 }
 
 // WithAttrs returns a new handler with the given attributes.
 func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return &contextHandler{
-		inner:       h.inner.WithAttrs(attrs),
-		contextKeys: h.contextKeys,
+	h2 := *h
+	h2.inner = h.inner.WithAttrs(attrs)
+	if h.output.conflict() != ContextConflictKeepBoth {
+		h2.preset = appendPresetKeys(h.preset, attrs)
 	}
+	return &h2
 }
 
 // WithGroup returns a new handler with the given group name.
 func (h *contextHandler) WithGroup(name string) slog.Handler {
-	return &contextHandler{
-		inner:       h.inner.WithGroup(name),
-		contextKeys: h.contextKeys,
+	h2 := *h
+	h2.inner = h.inner.WithGroup(name)
+	if name != "" {
+		h2.preset = nil
 	}
+	return &h2
 }
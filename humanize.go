@@ -0,0 +1,233 @@
+package xlogging
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processStart is the reference point of TimeModeElapsed.
+var processStart = time.Now()
+
+// Bytes is a byte count. The console handler renders it with binary units,
+// e.g. "3.4 MiB"; JSON output renders the plain number.
+//
+//	log.Info("upload finished", "size", xlogging.Bytes(n))
+type Bytes int64
+
+// String returns the byte count with binary units, e.g. "512 B" or "3.4 MiB".
+func (b Bytes) String() string {
+	return string(appendBytes(nil, b))
+}
+
+// byteUnits are the binary units used by Bytes, in increasing order.
+var byteUnits = [...]string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// appendBytes renders a byte count with binary units and one decimal.
+func appendBytes(buf []byte, b Bytes) []byte {
+	// The magnitude is unsigned, so that negating math.MinInt64 does not overflow
+	n := uint64(b)
+	if b < 0 {
+		buf = append(buf, '-')
+		n = -n
+	}
+	if n < 1024 {
+		buf = strconv.AppendUint(buf, n, 10)
+		return append(buf, " B"...)
+	}
+	value := float64(n)
+	unit := -1
+	for value >= 1024 && unit < len(byteUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	buf = appendTrimmedFloat(buf, value, 1)
+	buf = append(buf, ' ')
+	return append(buf, byteUnits[unit]...)
+}
+
+// appendDuration renders a duration with three significant digits in its
+// largest unit below a minute, e.g. "1.2s" or "350µs". Longer durations are
+// rounded to the second, e.g. "1h2m3s".
+func appendDuration(buf []byte, d time.Duration) []byte {
+	if d < 0 && d != math.MinInt64 {
+		buf = append(buf, '-')
+		d = -d
+	}
+	switch {
+	case d < time.Microsecond:
+		buf = strconv.AppendInt(buf, int64(d), 10)
+		return append(buf, "ns"...)
+	case d < time.Millisecond:
+		return appendSignificant(buf, float64(d)/float64(time.Microsecond), "µs")
+	case d < time.Second:
+		return appendSignificant(buf, float64(d)/float64(time.Millisecond), "ms")
+	case d < time.Minute:
+		return appendSignificant(buf, d.Seconds(), "s")
+	default:
+		return append(buf, d.Round(time.Second).String()...)
+	}
+}
+
+// appendSignificant renders a value in [1, 1000) with three significant digits and a unit.
+func appendSignificant(buf []byte, value float64, unit string) []byte {
+	decimals := 0
+	switch {
+	case value < 10:
+		decimals = 2
+	case value < 100:
+		decimals = 1
+	}
+	buf = appendTrimmedFloat(buf, value, decimals)
+	return append(buf, unit...)
+}
+
+// appendFloat renders a float with at most six decimals, avoiding artifacts
+// such as 0.30000000000000004. Very small and very large values use exponent notation.
+func appendFloat(buf []byte, f float64) []byte {
+	if abs := math.Abs(f); math.IsNaN(f) || math.IsInf(f, 0) || (abs != 0 && (abs < 1e-4 || abs >= 1e15)) {
+		return strconv.AppendFloat(buf, f, 'g', 6, 64)
+	}
+	return appendTrimmedFloat(buf, f, 6)
+}
+
+// appendTrimmedFloat renders a float with the given decimals, dropping trailing zeros.
+func appendTrimmedFloat(buf []byte, f float64, decimals int) []byte {
+	buf = strconv.AppendFloat(buf, f, 'f', decimals, 64)
+	if decimals == 0 {
+		return buf
+	}
+	for buf[len(buf)-1] == '0' {
+		buf = buf[:len(buf)-1]
+	}
+	if buf[len(buf)-1] == '.' {
+		buf = buf[:len(buf)-1]
+	}
+	return buf
+}
+
+// relativeTimeLimit is the distance from now beyond which time values are rendered absolutely.
+const relativeTimeLimit = 24 * time.Hour
+
+// appendTime renders a time value relative to now, e.g. "3m12s ago" or "in 5s".
+// Times more than a day away are rendered in RFC 3339.
+func appendTime(buf []byte, t, now time.Time) []byte {
+	d := now.Sub(t)
+	if d >= relativeTimeLimit || d <= -relativeTimeLimit {
+		return t.AppendFormat(buf, time.RFC3339Nano)
+	}
+	switch {
+	case d == 0:
+		return append(buf, "now"...)
+	case d > 0:
+		buf = appendRelative(buf, d)
+		return append(buf, " ago"...)
+	default:
+		buf = append(buf, "in "...)
+		return appendRelative(buf, -d)
+	}
+}
+
+// appendRelative renders the distance of a relative time, rounded to the second
+// unless it is shorter than one.
+func appendRelative(buf []byte, d time.Duration) []byte {
+	if d >= time.Second {
+		d = d.Round(time.Second)
+	}
+	return appendDuration(buf, d)
+}
+
+// TimeMode selects how the console handler renders the timestamp of each record.
+type TimeMode int
+
+// Time modes.
+const (
+	TimeModeClock   TimeMode = iota // wall clock with milliseconds, e.g. 15:04:05.000
+	TimeModeRFC3339                 // date, time with milliseconds and zone, e.g. 2006-01-02T15:04:05.000Z
+	TimeModeElapsed                 // time since process start, e.g. 00:01:02.345
+	TimeModeDelta                   // time since the previous record, e.g. +0.015s
+)
+
+// Timestamp layouts of the time modes.
+const (
+	clockLayout   = "15:04:05.000"
+	rfc3339Layout = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// String returns the name of the time mode, as accepted by the TIME_MODE environment variable.
+func (m TimeMode) String() string {
+	switch m {
+	case TimeModeClock:
+		return "clock"
+	case TimeModeRFC3339:
+		return "rfc3339"
+	case TimeModeElapsed:
+		return "elapsed"
+	case TimeModeDelta:
+		return "delta"
+	default:
+		return "TimeMode(" + strconv.Itoa(int(m)) + ")"
+	}
+}
+
+// parseTimeMode parses a time mode name (case-insensitive).
+func parseTimeMode(s string) (TimeMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "clock", "":
+		return TimeModeClock, nil
+	case "rfc3339":
+		return TimeModeRFC3339, nil
+	case "elapsed":
+		return TimeModeElapsed, nil
+	case "delta":
+		return TimeModeDelta, nil
+	default:
+		return TimeModeClock, fmt.Errorf("xlogging: unknown time mode %q", s)
+	}
+}
+
+// appendTimestamp renders the leading timestamp of a record in the given mode.
+// For TimeModeDelta, prev is the time of the previous record, or zero.
+func appendTimestamp(buf []byte, mode TimeMode, t, prev time.Time) []byte {
+	switch mode {
+	case TimeModeRFC3339:
+		return t.AppendFormat(buf, rfc3339Layout)
+	case TimeModeElapsed:
+		return appendClockDuration(buf, t.Sub(processStart))
+	case TimeModeDelta:
+		var d time.Duration
+		if !prev.IsZero() {
+			d = max(t.Sub(prev), 0)
+		}
+		buf = append(buf, '+')
+		buf = strconv.AppendFloat(buf, d.Seconds(), 'f', 3, 64)
+		return append(buf, 's')
+	default:
+		return t.AppendFormat(buf, clockLayout)
+	}
+}
+
+// appendClockDuration renders a duration as hh:mm:ss.mmm.
+func appendClockDuration(buf []byte, d time.Duration) []byte {
+	d = max(d, 0)
+	ms := d.Milliseconds()
+	buf = appendPadded(buf, ms/3_600_000, 2)
+	buf = append(buf, ':')
+	buf = appendPadded(buf, ms/60_000%60, 2)
+	buf = append(buf, ':')
+	buf = appendPadded(buf, ms/1000%60, 2)
+	buf = append(buf, '.')
+	return appendPadded(buf, ms%1000, 3)
+}
+
+// appendPadded renders a non-negative integer padded with zeros to the given width.
+func appendPadded(buf []byte, n int64, width int) []byte {
+	for digits, v := 1, n/10; digits < width; digits, v = digits+1, v/10 {
+		if v == 0 {
+			buf = append(buf, '0')
+		}
+	}
+	return strconv.AppendInt(buf, n, 10)
+}
@@ -0,0 +1,195 @@
+package xlogging
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Limits of the adaptive columns of the aligned layout. Longer values are not
+// truncated, but do not widen the column for the records that follow.
+const (
+	maxAdaptiveMessageWidth = 40
+	maxAdaptiveContextWidth = 40
+)
+
+// minWrapWidth is the narrowest line width at which attributes are wrapped.
+const minWrapWidth = 40
+
+// Layout configures the aligned console layout enabled by WithLayout.
+// The message is padded to a column so that attributes line up, context values
+// extracted with WithContextKeys get a fixed position after it, and long
+// attribute lists wrap onto indented continuation lines.
+type Layout struct {
+	// MessageWidth is the width of the message column. Zero adapts the column
+	// to the longest message logged so far, up to 40 characters.
+	MessageWidth int
+	// Width is the line width at which attributes wrap. Zero uses the width of
+	// the terminal, if the output is one; a negative value disables wrapping.
+	Width int
+}
+
+// parseLayout parses layout settings in the form "aligned,message=40,width=120".
+// The values "compact" and "off" select the default single-line layout and yield nil.
+func parseLayout(s string) (*Layout, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "compact") || strings.EqualFold(s, "off") {
+		return nil, nil
+	}
+
+	var layout Layout
+	for _, field := range splitList(s) {
+		if strings.EqualFold(field, "aligned") {
+			continue
+		}
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("xlogging: invalid layout field %q", field)
+		}
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "message":
+			layout.MessageWidth, err = strconv.Atoi(strings.TrimSpace(val))
+		case "width":
+			layout.Width, err = strconv.Atoi(strings.TrimSpace(val))
+		default:
+			return nil, fmt.Errorf("xlogging: unknown layout field %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xlogging: invalid layout %s: %w", key, err)
+		}
+	}
+	return &layout, nil
+}
+
+// alignedLayout is the state of the aligned layout, shared by a colorHandler and its clones.
+type alignedLayout struct {
+	messageWidth  int // fixed width of the message column, or 0 to adapt
+	width         int // line width at which attributes wrap, or 0
+	adaptiveWidth atomic.Int32
+	contextWidths []atomic.Int32 // adaptive widths of the context values, by key index
+}
+
+// newAlignedLayout creates the layout state for the given settings, context keys
+// and detected terminal width.
+func newAlignedLayout(l Layout, contextKeys int, terminalWidth int) *alignedLayout {
+	a := &alignedLayout{
+		messageWidth:  max(l.MessageWidth, 0),
+		width:         l.Width,
+		contextWidths: make([]atomic.Int32, contextKeys),
+	}
+	if a.width == 0 {
+		a.width = terminalWidth
+	}
+	if a.width < minWrapWidth {
+		a.width = 0
+	}
+	return a
+}
+
+// adapt returns the width of a column for a value of n characters, widening
+// the column up to limit.
+func adapt(column *atomic.Int32, n, limit int) int {
+	for {
+		cur := int(column.Load())
+		if n <= cur || n > limit {
+			return cur
+		}
+		if column.CompareAndSwap(int32(cur), int32(n)) {
+			return n
+		}
+	}
+}
+
+// messageColumn returns the width of the message column for a message of n characters.
+func (a *alignedLayout) messageColumn(n int) int {
+	if a.messageWidth > 0 {
+		return a.messageWidth
+	}
+	return adapt(&a.adaptiveWidth, n, maxAdaptiveMessageWidth)
+}
+
+// contextColumn returns the width of the column of the i-th context key for a
+// value of n characters. A negative n reports the width without a value.
+func (a *alignedLayout) contextColumn(i, n int) int {
+	if n < 0 {
+		return int(a.contextWidths[i].Load())
+	}
+	return adapt(&a.contextWidths[i], n, maxAdaptiveContextWidth)
+}
+
+// appendSpaces appends n spaces.
+func appendSpaces(buf []byte, n int) []byte {
+	for ; n > 0; n-- {
+		buf = append(buf, ' ')
+	}
+	return buf
+}
+
+// visibleWidth returns the number of characters of b displayed on a terminal,
+// excluding ANSI escape sequences and the targets of OSC 8 hyperlinks.
+func visibleWidth(b []byte) int {
+	n := 0
+	for i := 0; i < len(b); {
+		if b[i] == '\033' && i+1 < len(b) && b[i+1] == '[' {
+			i += 2
+			for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+				i++
+			}
+			i++
+			continue
+		}
+		if b[i] == '\033' && i+1 < len(b) && b[i+1] == ']' {
+			// Operating system command, terminated by BEL or ESC \
+			i += 2
+			for i < len(b) && b[i] != '\a' && !(b[i] == '\033' && i+1 < len(b) && b[i+1] == '\\') {
+				i++
+			}
+			if i < len(b) && b[i] == '\a' {
+				i++
+			} else {
+				i += 2
+			}
+			continue
+		}
+		_, size := utf8.DecodeRune(b[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+// wrap breaks the attributes of a line onto continuation lines so that no line
+// exceeds width, where possible. Each attribute occupies buf[ends[i-1]:ends[i]]
+// (starting at start for the first) and begins with a space, which is replaced
+// by a line break and indent spaces. The column of start is col; continuation
+// lines are indented to the column of the first key unless that exceeds half the width.
+func wrap(buf []byte, start int, ends []int, col, width, indent int) []byte {
+	if width <= 0 || len(ends) == 0 {
+		return buf
+	}
+	if indent > width/2 {
+		indent = 4
+	}
+	lineBreak := appendSpaces([]byte{'\n'}, indent)
+
+	shift := 0
+	lineEmpty := col+1 <= indent // breaking before the next attribute would not move it left
+	for _, end := range ends {
+		segStart, segEnd := start+shift, end+shift
+		w := visibleWidth(buf[segStart:segEnd])
+		if col+w > width && !lineEmpty {
+			buf = slices.Replace(buf, segStart, segStart+1, lineBreak...)
+			shift += len(lineBreak) - 1
+			col = indent + w - 1
+		} else {
+			col += w
+		}
+		lineEmpty = false
+		start = end
+	}
+	return buf
+}
@@ -1,8 +1,14 @@
 package xlogging
 
 import (
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 // Level represents a logging level.
@@ -10,26 +16,238 @@ type Level = slog.Level
 
 // Logging levels.
 const (
-	LevelDebug = slog.LevelDebug
-	LevelInfo  = slog.LevelInfo
-	LevelWarn  = slog.LevelWarn
-	LevelError = slog.LevelError
+	LevelTrace    = slog.Level(-8)
+	LevelDebug    = slog.LevelDebug
+	LevelInfo     = slog.LevelInfo
+	LevelNotice   = slog.Level(2)
+	LevelWarn     = slog.LevelWarn
+	LevelError    = slog.LevelError
+	LevelCritical = slog.Level(10)
+	LevelPanic    = slog.Level(12)
+	LevelFatal    = slog.Level(16)
 )
 
+// registeredLevel is a level known to the level registry.
+type registeredLevel struct {
+	level Level
+	name  string
+	color Color
+}
+
+// levelRegistry holds the registered levels sorted by level.
+var levelRegistry = struct {
+	sync.RWMutex
+	levels []registeredLevel
+	width  int // length of the longest name
+}{
+	width: len("CRITICAL"),
+	levels: []registeredLevel{
+		{LevelTrace, "TRACE", ColorGray},
+		{LevelDebug, "DEBUG", ColorBlue},
+		{LevelInfo, "INFO", ColorGreen},
+		{LevelNotice, "NOTICE", ColorCyan},
+		{LevelWarn, "WARN", ColorYellow},
+		{LevelError, "ERROR", ColorRed},
+		{LevelCritical, "CRITICAL", ColorBrightRed},
+		{LevelPanic, "PANIC", ColorMagenta},
+		{LevelFatal, "FATAL", ColorMagenta},
+	},
+}
+
+// RegisterLevel registers a named level with the given console color.
+// Registered names are accepted by ParseLevel and used by all output formats.
+// Registering an existing level replaces its name and color.
+func RegisterLevel(level Level, name string, color Color) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+
+	levelRegistry.Lock()
+	defer levelRegistry.Unlock()
+
+	levels := make([]registeredLevel, 0, len(levelRegistry.levels)+1)
+	for _, l := range levelRegistry.levels {
+		if l.level != level && l.name != name {
+			levels = append(levels, l)
+		}
+	}
+	levels = append(levels, registeredLevel{level: level, name: name, color: color})
+	sort.Slice(levels, func(i, j int) bool { return levels[i].level < levels[j].level })
+	levelRegistry.levels = levels
+
+	levelRegistry.width = 0
+	for _, l := range levels {
+		levelRegistry.width = max(levelRegistry.width, utf8.RuneCountInString(l.name))
+	}
+}
+
+// levelNameWidth returns the length of the longest registered level name.
+func levelNameWidth() int {
+	levelRegistry.RLock()
+	defer levelRegistry.RUnlock()
+	return levelRegistry.width
+}
+
+// lookupLevel returns the registered level closest to level from below,
+// or the lowest registered level if level is below all of them.
+func lookupLevel(level Level) registeredLevel {
+	levelRegistry.RLock()
+	defer levelRegistry.RUnlock()
+
+	found := levelRegistry.levels[0]
+	for _, l := range levelRegistry.levels {
+		if l.level > level {
+			break
+		}
+		found = l
+	}
+	return found
+}
+
+// levelByName returns the registered level with the given name (case-insensitive).
+func levelByName(name string) (Level, bool) {
+	levelRegistry.RLock()
+	defer levelRegistry.RUnlock()
+
+	for _, l := range levelRegistry.levels {
+		if strings.EqualFold(l.name, name) {
+			return l.level, true
+		}
+	}
+	return 0, false
+}
+
 // ParseLevel parses a level string into a Level.
-// Supported values (case-insensitive): debug, info, warn, warning, error.
+// It accepts the same values as ParseLevelStrict.
 // Returns LevelInfo if the string is not recognized.
 func ParseLevel(s string) Level {
-	switch strings.ToLower(strings.TrimSpace(s)) {
-	case "debug":
-		return LevelDebug
-	case "info":
-		return LevelInfo
-	case "warn", "warning":
-		return LevelWarn
-	case "error":
-		return LevelError
-	default:
+	level, err := ParseLevelStrict(s)
+	if err != nil {
 		return LevelInfo
 	}
+	return level
+}
+
+// ParseLevelStrict parses a level string into a Level, reporting unrecognized values.
+// Supported values (case-insensitive) are the names of registered levels
+// (trace, debug, info, notice, warn, error, critical, panic, fatal by default),
+// warning as an alias for warn, a name followed by an offset (e.g. "info+2",
+// "debug-1") and plain numeric values (e.g. "-4", "12").
+func ParseLevelStrict(s string) (Level, error) {
+	str := strings.TrimSpace(s)
+	if str == "" {
+		return 0, fmt.Errorf("xlogging: empty level")
+	}
+	if n, err := strconv.Atoi(str); err == nil {
+		return Level(n), nil
+	}
+
+	name, offset := str, 0
+	if i := strings.LastIndexAny(str, "+-"); i > 0 {
+		n, err := strconv.Atoi(str[i:])
+		if err != nil {
+			return 0, fmt.Errorf("xlogging: invalid level offset in %q", s)
+		}
+		name, offset = str[:i], n
+	}
+
+	if strings.EqualFold(name, "warning") {
+		return LevelWarn + Level(offset), nil
+	}
+	if level, ok := levelByName(name); ok {
+		return level + Level(offset), nil
+	}
+	return 0, fmt.Errorf("xlogging: unknown level %q", s)
+}
+
+// levelName returns the display name of the level.
+// Unregistered levels are rendered relative to the closest registered level,
+// the way slog does (e.g. "INFO+1").
+func levelName(level Level) string {
+	base := lookupLevel(level)
+	if base.level == level {
+		return base.name
+	}
+	return fmt.Sprintf("%s%+d", base.name, level-base.level)
+}
+
+// levelColor returns the console color of the level.
+func levelColor(level Level) Color {
+	return lookupLevel(level).color
+}
+
+// replaceLevelAttr renders the level attribute using levelName.
+// It is used as slog.HandlerOptions.ReplaceAttr for the JSON and text handlers.
+func replaceLevelAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.LevelKey {
+		if level, ok := a.Value.Any().(slog.Level); ok {
+			a.Value = slog.StringValue(levelName(level))
+		}
+	}
+	return a
+}
+
+// LevelFlag is a Level that can be set from command-line flags and configuration files.
+// It implements flag.Value, encoding.TextMarshaler, encoding.TextUnmarshaler,
+// json.Marshaler, json.Unmarshaler and the YAML unmarshaler interface.
+// Values are parsed with ParseLevelStrict.
+type LevelFlag Level
+
+// Level returns the flag value as a Level.
+// It makes LevelFlag usable as a slog.Leveler.
+func (f LevelFlag) Level() Level {
+	return Level(f)
+}
+
+// String returns the name of the level.
+func (f LevelFlag) String() string {
+	return levelName(Level(f))
+}
+
+// Set parses s into the flag value.
+func (f *LevelFlag) Set(s string) error {
+	level, err := ParseLevelStrict(s)
+	if err != nil {
+		return err
+	}
+	*f = LevelFlag(level)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (f LevelFlag) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (f *LevelFlag) UnmarshalText(text []byte) error {
+	return f.Set(string(text))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (f LevelFlag) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+// Both strings ("debug", "info+2") and numbers (-4) are accepted.
+func (f *LevelFlag) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("xlogging: invalid level %s", data)
+		}
+		*f = LevelFlag(n)
+		return nil
+	}
+	return f.Set(s)
+}
+
+// UnmarshalYAML implements the YAML unmarshaler interface
+// supported by both gopkg.in/yaml.v2 and gopkg.in/yaml.v3.
+func (f *LevelFlag) UnmarshalYAML(unmarshal func(any) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return f.Set(s)
 }
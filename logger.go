@@ -4,6 +4,9 @@ import (
 	"context"
 	"io"
 	"log/slog"
+	"os"
+	"runtime"
+	"time"
 )
 
 // Logger is the interface for structured logging.
@@ -26,30 +29,44 @@ type Logger interface {
 	// ErrorContext logs at error level with context.
 	ErrorContext(ctx context.Context, msg string, args ...any)
 
+	// Fatal logs at fatal level, flushes the output and exits the process.
+	Fatal(msg string, args ...any)
+	// FatalContext logs at fatal level with context, flushes the output and exits the process.
+	FatalContext(ctx context.Context, msg string, args ...any)
+	// Panic logs at panic level and then panics with the message.
+	Panic(msg string, args ...any)
+	// PanicContext logs at panic level with context and then panics with the message.
+	PanicContext(ctx context.Context, msg string, args ...any)
+
 	// With returns a new Logger with the given attributes.
 	With(args ...any) Logger
 	// WithGroup returns a new Logger with the given group name.
 	WithGroup(name string) Logger
+	// WithContext returns a new Logger whose methods without a context argument,
+	// such as Info, behave as if ctx were passed. Context methods use their own context.
+	WithContext(ctx context.Context) Logger
 	// Handler returns the underlying slog.Handler.
 	Handler() slog.Handler
 }
 
+// nameKey is the attribute key for the logger name set by WithName.
+const nameKey = "logger"
+
 // logger is the concrete implementation of Logger.
 type logger struct {
-	slog *slog.Logger
+	slog   *slog.Logger
+	output io.Writer
+	exit   func(code int)
+	ctx    context.Context // context of the methods without one; nil means context.Background()
 }
 
 // New creates a new Logger with the given options.
 func New(opts ...Option) Logger {
-	cfg := defaultConfig()
-	for _, opt := range opts {
-		opt(cfg)
-	}
-	return newLoggerFromConfig(cfg)
+	return newLoggerFromConfig(newConfig(opts))
 }
 
 // Default creates a new Logger with auto-detected configuration.
-// It reads XLOG_ENV and XLOG_LEVEL environment variables.
+// It reads the XLOG_* environment variables.
 func Default() Logger {
 	return New()
 }
@@ -57,97 +74,216 @@ func Default() Logger {
 // newLoggerFromConfig creates a logger from the given configuration.
 func newLoggerFromConfig(cfg *config) Logger {
 	handler := createHandler(cfg)
-	return &logger{
-		slog: slog.New(handler),
+	l := &logger{
+		slog:   slog.New(handler),
+		output: cfg.output,
+		exit:   cfg.exitFunc,
+	}
+	for _, err := range cfg.warnings {
+		l.warnConfig(err)
 	}
+	return l
+}
+
+// warnConfig reports a configuration problem detected while creating the logger.
+// The warning bypasses the level check so that it is never silently dropped.
+func (l *logger) warnConfig(err error) {
+	r := slog.NewRecord(time.Now(), LevelWarn, "xlogging: invalid configuration", 0)
+	r.AddAttrs(slog.Any("error", err))
+	_ = l.slog.Handler().Handle(context.Background(), r)
 }
 
 // createHandler creates the appropriate handler chain based on config.
 func createHandler(cfg *config) slog.Handler {
-	var baseHandler slog.Handler
+	var handler slog.Handler
+	level := cfg.leveler()
+	redactor := cfg.buildRedactor()
+	useColor := false
 
 	if cfg.shouldUseJSON() {
-		baseHandler = slog.NewJSONHandler(cfg.output, &slog.HandlerOptions{
-			Level:     cfg.level,
-			AddSource: cfg.addSource,
-		})
-	} else if cfg.shouldUseColor() {
-		baseHandler = newColorHandler(cfg.output, &colorHandlerOptions{
-			Level:       cfg.level,
+		handler = slog.NewJSONHandler(cfg.output, &slog.HandlerOptions{
+			Level:       level,
 			AddSource:   cfg.addSource,
-			ContextKeys: cfg.contextKeys,
+			ReplaceAttr: replaceLevelAttr,
 		})
+	} else if cfg.shouldUseColor() {
 		// Color handler handles context keys directly, no need to wrap
-		return baseHandler
+		handler = newColorHandler(cfg.output, &colorHandlerOptions{
+			Level:           level,
+			AddSource:       cfg.addSource,
+			ContextKeys:     cfg.contextKeys,
+			Extractors:      cfg.extractors,
+			ContextOutput:   cfg.contextOutput,
+			Redactor:        redactor,
+			ColorDepth:      cfg.colorDepth(),
+			Theme:           cfg.theme,
+			HighlightKeys:   cfg.highlightKeys,
+			HighlightValues: cfg.highlightVals,
+			Expanded:        cfg.expanded,
+			TimeMode:        cfg.timeMode,
+			Layout:          cfg.layout,
+			RequestColors:   cfg.requestColors,
+			SourceLinks:     cfg.sourceLinks,
+			Verbatim:        cfg.verbatim,
+		})
+		useColor = true
 	} else {
-		baseHandler = slog.NewTextHandler(cfg.output, &slog.HandlerOptions{
-			Level:     cfg.level,
-			AddSource: cfg.addSource,
+		handler = slog.NewTextHandler(cfg.output, &slog.HandlerOptions{
+			Level:       level,
+			AddSource:   cfg.addSource,
+			ReplaceAttr: replaceLevelAttr,
 		})
+		if !cfg.verbatim {
+			handler = &textSanitizer{inner: handler}
+		}
+	}
+
+	// Redact before formatting so that context values are covered too; the color
+	// handler adds context values itself and redacts them with the same redactor
+	if redactor != nil {
+		handler = newRedactHandler(handler, redactor)
+	}
+
+	// Wrap with context handler for the attributes of AppendCtx, context keys and extractors
+	if !useColor {
+		handler = newContextHandler(handler, cfg.contextKeys, cfg.extractors, cfg.contextOutput)
+	}
+
+	// Sample first so that dropped records cost as little as possible
+	if s := cfg.buildSampler(); s != nil {
+		handler = newSamplingHandler(handler, s)
+	}
+
+	// Within BufferRequest, records of every level are buffered until the request
+	// ends; inside the flight recorder, so that committed records reach the output
+	handler = newBufferHandler(handler)
+
+	// Buffer records below the level outermost, so that they are sampled only when logged
+	if fr := cfg.buildFlightRecorder(); fr != nil {
+		handler = newFlightRecorderHandler(handler, fr)
 	}
 
-	// Wrap with context handler if context keys are specified
-	if len(cfg.contextKeys) > 0 {
-		return newContextHandler(baseHandler, cfg.contextKeys)
+	if cfg.name != "" {
+		handler = handler.WithAttrs([]slog.Attr{slog.String(nameKey, cfg.name)})
 	}
 
-	return baseHandler
+	return handler
+}
+
+// log emits a record at the given level.
+// The source location is taken from the caller of the exported logging method.
+func (l *logger) log(ctx context.Context, level Level, msg string, args ...any) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	handler := l.slog.Handler()
+	if !handler.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:]) // skip [Callers, log, exported method]
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.Add(args...)
+	_ = handler.Handle(ctx, r)
 }
 
 // Debug logs at debug level.
 func (l *logger) Debug(msg string, args ...any) {
-	l.slog.Debug(msg, args...)
+	l.log(l.ctx, LevelDebug, msg, args...)
 }
 
 // Info logs at info level.
 func (l *logger) Info(msg string, args ...any) {
-	l.slog.Info(msg, args...)
+	l.log(l.ctx, LevelInfo, msg, args...)
 }
 
 // Warn logs at warn level.
 func (l *logger) Warn(msg string, args ...any) {
-	l.slog.Warn(msg, args...)
+	l.log(l.ctx, LevelWarn, msg, args...)
 }
 
 // Error logs at error level.
 func (l *logger) Error(msg string, args ...any) {
-	l.slog.Error(msg, args...)
+	l.log(l.ctx, LevelError, msg, args...)
 }
 
 // DebugContext logs at debug level with context.
 func (l *logger) DebugContext(ctx context.Context, msg string, args ...any) {
-	l.slog.DebugContext(ctx, msg, args...)
+	l.log(ctx, LevelDebug, msg, args...)
 }
 
 // InfoContext logs at info level with context.
 func (l *logger) InfoContext(ctx context.Context, msg string, args ...any) {
-	l.slog.InfoContext(ctx, msg, args...)
+	l.log(ctx, LevelInfo, msg, args...)
 }
 
 // WarnContext logs at warn level with context.
 func (l *logger) WarnContext(ctx context.Context, msg string, args ...any) {
-	l.slog.WarnContext(ctx, msg, args...)
+	l.log(ctx, LevelWarn, msg, args...)
 }
 
 // ErrorContext logs at error level with context.
 func (l *logger) ErrorContext(ctx context.Context, msg string, args ...any) {
-	l.slog.ErrorContext(ctx, msg, args...)
+	l.log(ctx, LevelError, msg, args...)
+}
+
+// Fatal logs at fatal level, flushes the output and exits the process.
+func (l *logger) Fatal(msg string, args ...any) {
+	l.log(l.ctx, LevelFatal, msg, args...)
+	l.terminate()
+}
+
+// FatalContext logs at fatal level with context, flushes the output and exits the process.
+func (l *logger) FatalContext(ctx context.Context, msg string, args ...any) {
+	l.log(ctx, LevelFatal, msg, args...)
+	l.terminate()
+}
+
+// Panic logs at panic level and then panics with the message.
+func (l *logger) Panic(msg string, args ...any) {
+	l.log(l.ctx, LevelPanic, msg, args...)
+	panic(msg)
+}
+
+// PanicContext logs at panic level with context and then panics with the message.
+func (l *logger) PanicContext(ctx context.Context, msg string, args ...any) {
+	l.log(ctx, LevelPanic, msg, args...)
+	panic(msg)
+}
+
+// terminate flushes the output and calls the exit function with status 1.
+func (l *logger) terminate() {
+	_ = flushOutput(l.output)
+	l.exit(1)
 }
 
 // With returns a new Logger with the given attributes.
 func (l *logger) With(args ...any) Logger {
 	return &logger{
-		slog: l.slog.With(args...),
+		slog:   l.slog.With(args...),
+		output: l.output,
+		exit:   l.exit,
+		ctx:    l.ctx,
 	}
 }
 
 // WithGroup returns a new Logger with the given group name.
 func (l *logger) WithGroup(name string) Logger {
 	return &logger{
-		slog: l.slog.WithGroup(name),
+		slog:   l.slog.WithGroup(name),
+		output: l.output,
+		exit:   l.exit,
+		ctx:    l.ctx,
 	}
 }
 
+// WithContext returns a new Logger whose methods without a context argument use ctx.
+func (l *logger) WithContext(ctx context.Context) Logger {
+	l2 := *l
+	l2.ctx = ctx
+	return &l2
+}
+
 // Handler returns the underlying slog.Handler.
 func (l *logger) Handler() slog.Handler {
 	return l.slog.Handler()
@@ -156,6 +292,20 @@ func (l *logger) Handler() slog.Handler {
 // Discard returns a Logger that discards all log output.
 func Discard() Logger {
 	return &logger{
-		slog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		slog:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		output: io.Discard,
+		exit:   os.Exit,
+	}
+}
+
+// flushOutput flushes buffered data of w if it supports Sync or Flush.
+func flushOutput(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Sync() error }:
+		return f.Sync()
+	case interface{ Flush() error }:
+		return f.Flush()
+	default:
+		return nil
 	}
 }
@@ -16,19 +16,22 @@ type LogEntry struct {
 
 // TestLogger is a Logger implementation for testing that captures log entries.
 type TestLogger struct {
-	mu      *sync.Mutex
-	entries *[]LogEntry
-	attrs   map[string]any
-	group   string
+	mu        *sync.Mutex
+	entries   *[]LogEntry
+	exitCodes *[]int
+	attrs     map[string]any
+	group     string
 }
 
 // NewTestLogger creates a new TestLogger for testing.
 func NewTestLogger() *TestLogger {
 	entries := make([]LogEntry, 0)
+	exitCodes := make([]int, 0)
 	return &TestLogger{
-		mu:      &sync.Mutex{},
-		entries: &entries,
-		attrs:   make(map[string]any),
+		mu:        &sync.Mutex{},
+		entries:   &entries,
+		exitCodes: &exitCodes,
+		attrs:     make(map[string]any),
 	}
 }
 
@@ -102,16 +105,48 @@ func (t *TestLogger) ErrorContext(_ context.Context, msg string, args ...any) {
 	t.log(LevelError, msg, args...)
 }
 
+// Fatal logs at fatal level and records exit code 1 instead of exiting.
+func (t *TestLogger) Fatal(msg string, args ...any) {
+	t.log(LevelFatal, msg, args...)
+	t.exit(1)
+}
+
+// FatalContext logs at fatal level with context and records exit code 1 instead of exiting.
+func (t *TestLogger) FatalContext(_ context.Context, msg string, args ...any) {
+	t.log(LevelFatal, msg, args...)
+	t.exit(1)
+}
+
+// Panic logs at panic level and then panics with the message.
+func (t *TestLogger) Panic(msg string, args ...any) {
+	t.log(LevelPanic, msg, args...)
+	panic(msg)
+}
+
+// PanicContext logs at panic level with context and then panics with the message.
+func (t *TestLogger) PanicContext(_ context.Context, msg string, args ...any) {
+	t.log(LevelPanic, msg, args...)
+	panic(msg)
+}
+
+// exit records the exit code requested by Fatal.
+func (t *TestLogger) exit(code int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*t.exitCodes = append(*t.exitCodes, code)
+}
+
 // With returns a new TestLogger with the given attributes.
 func (t *TestLogger) With(args ...any) Logger {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	newLogger := &TestLogger{
-		mu:      t.mu,      // Share the mutex
-		entries: t.entries, // Share the entries slice
-		attrs:   make(map[string]any),
-		group:   t.group,
+		mu:        t.mu,        // Share the mutex
+		entries:   t.entries,   // Share the entries slice
+		exitCodes: t.exitCodes, // Share the exit codes
+		attrs:     make(map[string]any),
+		group:     t.group,
 	}
 
 	// Copy existing attrs
@@ -140,10 +175,11 @@ func (t *TestLogger) WithGroup(name string) Logger {
 	}
 
 	newLogger := &TestLogger{
-		mu:      t.mu,      // Share the mutex
-		entries: t.entries, // Share the entries slice
-		attrs:   make(map[string]any),
-		group:   newGroup,
+		mu:        t.mu,        // Share the mutex
+		entries:   t.entries,   // Share the entries slice
+		exitCodes: t.exitCodes, // Share the exit codes
+		attrs:     make(map[string]any),
+		group:     newGroup,
 	}
 
 	// Copy existing attrs
@@ -154,6 +190,11 @@ func (t *TestLogger) WithGroup(name string) Logger {
 	return newLogger
 }
 
+// WithContext returns the TestLogger itself, since it does not extract context values.
+func (t *TestLogger) WithContext(_ context.Context) Logger {
+	return t
+}
+
 // Handler returns nil for TestLogger (not backed by slog.Handler).
 func (t *TestLogger) Handler() slog.Handler {
 	return nil
@@ -169,11 +210,24 @@ func (t *TestLogger) Entries() []LogEntry {
 	return result
 }
 
-// Clear removes all captured log entries.
+// ExitCode returns the exit code of the last Fatal call.
+// The second result reports whether Fatal has been called at all.
+func (t *TestLogger) ExitCode() (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(*t.exitCodes) == 0 {
+		return 0, false
+	}
+	return (*t.exitCodes)[len(*t.exitCodes)-1], true
+}
+
+// Clear removes all captured log entries and recorded exit codes.
 func (t *TestLogger) Clear() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	*t.entries = (*t.entries)[:0]
+	*t.exitCodes = (*t.exitCodes)[:0]
 }
 
 // HasEntry checks if there's an entry with the given level and message substring.
@@ -1,48 +1,164 @@
 package xlogging
 
 import (
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"strings"
 )
 
+// Format is the output format of a Logger.
+type Format string
+
+// Output formats.
+const (
+	FormatAuto Format = ""     // JSON in production, text otherwise
+	FormatJSON Format = "json" // JSON lines
+	FormatText Format = "text" // key=value text, colored if enabled
+)
+
+// parseFormat parses a format string (case-insensitive).
+// Both "" and "auto" are accepted for FormatAuto.
+func parseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case FormatAuto, FormatJSON, FormatText:
+		return f, nil
+	case "auto":
+		return FormatAuto, nil
+	default:
+		return FormatAuto, fmt.Errorf("xlogging: unknown format %q", s)
+	}
+}
+
 // config holds the logger configuration.
 type config struct {
-	env         Env
-	level       Level
-	output      io.Writer
-	contextKeys []ContextKey
-	addSource   bool
-	useColor    *bool // nil means auto-detect
+	envPrefix     string
+	env           Env
+	level         Level
+	levelProvider func(name string) slog.Leveler // set by ConfigFile, overrides level
+	nameLevels    map[string]Level
+	name          string
+	format        Format
+	output        io.Writer
+	contextKeys   []ContextKey
+	extractors    []ContextExtractor
+	contextOutput *ContextOutput
+	addSource     bool
+	useColor      *bool // nil means auto-detect
+	theme         *Theme
+	highlightKeys []string
+	highlightVals []string
+	expanded      bool
+	timeMode      TimeMode
+	layout        *Layout // nil means the compact layout
+	requestColors *RequestColors
+	sourceLinks   *SourceLinks
+	verbatim      bool // disables sanitization of console output
+	sampling      *Sampling
+	sampler       *sampler // shared with a ConfigFile, falls back to sampling
+	redactKeys    []string
+	flight        *FlightRecorder // nil disables the flight recorder
+	redactor      *redactor       // shared with a ConfigFile, falls back to redactKeys
+	exitFunc      func(code int)
+	overridden    keySet  // keys of the environment variables overridden by options
+	warnings      []error // reported by the created logger
 }
 
 // defaultConfig returns the default configuration.
 func defaultConfig() *config {
-	env := detectEnv()
 	return &config{
-		env:         env,
-		level:       detectLevel(env),
+		envPrefix:   defaultEnvPrefix,
+		env:         EnvDevelopment,
+		level:       LevelInfo,
 		output:      os.Stderr,
 		contextKeys: nil,
 		addSource:   false,
 		useColor:    nil,
+		exitFunc:    os.Exit,
+	}
+}
+
+// newConfig builds the configuration for the given options.
+// Options take precedence over environment variables. Because WithEnvPrefix
+// decides which variables are read, the options are applied first, recording
+// the variables they override, and the other variables are read afterwards.
+func newConfig(opts []Option) *config {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	cfg.loadEnv(cfg.envPrefix)
+	return cfg
+}
+
+// override records that an option overrides the environment variables with the given keys.
+func (c *config) override(keys ...string) {
+	if c.overridden == nil {
+		c.overridden = make(keySet)
+	}
+	for _, key := range keys {
+		c.overridden[key] = struct{}{}
 	}
 }
 
 // Option is a functional option for configuring a Logger.
 type Option func(*config)
 
+// WithEnvPrefix sets the prefix of the environment variables read by the logger.
+// Defaults to "XLOG_". Use distinct prefixes (e.g. "BILLING_") to configure
+// several components of one binary independently.
+func WithEnvPrefix(prefix string) Option {
+	return func(c *config) {
+		c.envPrefix = prefix
+	}
+}
+
 // WithEnv sets the environment for the logger.
 // This affects the output format (JSON for production, text for others).
 func WithEnv(env Env) Option {
 	return func(c *config) {
 		c.env = env
+		c.override(envKeyEnv)
 	}
 }
 
 // WithLevel sets the minimum log level.
+// It replaces any level previously set by a ConfigFile option.
 func WithLevel(level Level) Option {
 	return func(c *config) {
 		c.level = level
+		c.levelProvider = nil
+		c.override(envKeyLevel)
+	}
+}
+
+// WithLevelFor sets the minimum log level for loggers created with WithName(name).
+// It takes precedence over the levels of a ConfigFile option.
+func WithLevelFor(name string, level Level) Option {
+	return func(c *config) {
+		if c.nameLevels == nil {
+			c.nameLevels = make(map[string]Level)
+		}
+		c.nameLevels[name] = level
+	}
+}
+
+// WithName sets the name of the logger.
+// The name is added to every record as the "logger" attribute and selects
+// the level set by WithLevelFor.
+func WithName(name string) Option {
+	return func(c *config) {
+		c.name = name
+	}
+}
+
+// WithFormat sets the output format.
+// By default, the format is derived from the environment.
+func WithFormat(format Format) Option {
+	return func(c *config) {
+		c.format = format
+		c.override(envKeyFormat)
 	}
 }
 
@@ -50,6 +166,7 @@ func WithLevel(level Level) Option {
 func WithOutput(w io.Writer) Option {
 	return func(c *config) {
 		c.output = w
+		c.override(envKeyOutput)
 	}
 }
 
@@ -57,6 +174,26 @@ func WithOutput(w io.Writer) Option {
 func WithContextKeys(keys ...ContextKey) Option {
 	return func(c *config) {
 		c.contextKeys = keys
+		c.override(envKeyContextKeys)
+	}
+}
+
+// WithContextExtractor adds a function that turns context data, such as an
+// authenticated principal or a deadline, into attributes of every record
+// logged with that context. Extractors are called after WithContextKeys is
+// applied and may be added multiple times.
+func WithContextExtractor(extract ContextExtractor) Option {
+	return func(c *config) {
+		c.extractors = append(c.extractors, extract)
+	}
+}
+
+// WithContextOutput configures the names, grouping and conflict handling of
+// the attributes derived from the context.
+func WithContextOutput(o ContextOutput) Option {
+	return func(c *config) {
+		c.contextOutput = &o
+		c.override(envKeyContextOut)
 	}
 }
 
@@ -64,6 +201,16 @@ func WithContextKeys(keys ...ContextKey) Option {
 func WithSource(enabled bool) Option {
 	return func(c *config) {
 		c.addSource = enabled
+		c.override(envKeySource)
+	}
+}
+
+// WithSourceLinks sets how console output renders the source location added
+// by WithSource, e.g. as hyperlinks that open the editor. See SourceLinks.
+func WithSourceLinks(l SourceLinks) Option {
+	return func(c *config) {
+		c.sourceLinks = &l
+		c.override(envKeySourceLinks)
 	}
 }
 
@@ -72,6 +219,122 @@ func WithSource(enabled bool) Option {
 func WithColor(enabled bool) Option {
 	return func(c *config) {
 		c.useColor = &enabled
+		c.override(envKeyColor)
+	}
+}
+
+// WithTheme sets the colors of console output.
+// See DarkTheme, LightTheme and MonochromeTheme for the built-in themes.
+func WithTheme(theme Theme) Option {
+	return func(c *config) {
+		c.theme = &theme
+		c.override(envKeyTheme)
+	}
+}
+
+// WithHighlightKeys renders attributes with the given keys in the theme's highlight color.
+// Keys match with or without their group prefix (e.g. "id" or "order.id").
+func WithHighlightKeys(keys ...string) Option {
+	return func(c *config) {
+		c.highlightKeys = keys
+	}
+}
+
+// WithHighlightValues renders attribute values containing any of the given
+// strings in the theme's highlight color.
+func WithHighlightValues(values ...string) Option {
+	return func(c *config) {
+		c.highlightVals = values
+	}
+}
+
+// WithExpanded enables or disables the expanded console mode, in which structs,
+// maps, slices, JSON documents and multi-line strings are rendered as indented
+// blocks below the record instead of on one line. It has no effect on JSON and
+// plain text output.
+func WithExpanded(enabled bool) Option {
+	return func(c *config) {
+		c.expanded = enabled
+		c.override(envKeyExpanded)
+	}
+}
+
+// WithTimeMode sets how console output renders the timestamp of each record.
+// Defaults to TimeModeClock. It has no effect on JSON and plain text output.
+func WithTimeMode(mode TimeMode) Option {
+	return func(c *config) {
+		c.timeMode = mode
+		c.override(envKeyTimeMode)
+	}
+}
+
+// WithLayout enables the aligned console layout. See Layout for details.
+// It has no effect on JSON and plain text output.
+func WithLayout(l Layout) Option {
+	return func(c *config) {
+		c.layout = &l
+		c.override(envKeyLayout)
+	}
+}
+
+// WithRequestColors colors console output per request, so that the lines of
+// concurrent requests can be told apart. See RequestColors for details.
+// It has no effect on JSON and plain text output.
+func WithRequestColors(rc RequestColors) Option {
+	return func(c *config) {
+		c.requestColors = &rc
+		c.override(envKeyReqColors)
+	}
+}
+
+// WithSanitize enables or disables escaping of control characters, terminal
+// escape sequences and bidirectional formatting characters in console and text
+// output, which keeps user input from forging records or manipulating the
+// terminal. Enabled by default. Console values are always quoted and escaped as needed.
+func WithSanitize(enabled bool) Option {
+	return func(c *config) {
+		c.verbatim = !enabled
+		c.override(envKeySanitize)
+	}
+}
+
+// WithFlightRecorder keeps the last records below the configured level in
+// memory, per request, and logs them before the next error of the same request.
+func WithFlightRecorder(fr FlightRecorder) Option {
+	return func(c *config) {
+		c.flight = &fr
+		c.override(envKeyFlightRec)
+	}
+}
+
+// WithSampling limits the volume of repetitive records.
+// See Sampling for details.
+func WithSampling(s Sampling) Option {
+	return func(c *config) {
+		c.sampling = &s
+		c.sampler = nil
+		c.override(envKeySampling)
+	}
+}
+
+// WithRedact replaces the values of attributes with the given keys by "[REDACTED]".
+// Keys are matched case-insensitively at any group depth.
+func WithRedact(keys ...string) Option {
+	return func(c *config) {
+		c.redactKeys = keys
+		c.redactor = nil
+		c.override(envKeyRedact)
+	}
+}
+
+// WithExitFunc sets the function called by Fatal and FatalContext after the
+// output has been flushed. Defaults to os.Exit, which a nil fn restores.
+func WithExitFunc(fn func(code int)) Option {
+	return func(c *config) {
+		if fn == nil {
+			fn = os.Exit
+		}
+		c.exitFunc = fn
 	}
 }
 
@@ -80,21 +343,71 @@ func (c *config) shouldUseColor() bool {
 	if c.useColor != nil {
 		return *c.useColor
 	}
-	// Auto-detect: use color in non-production environments when output is a terminal
+	// Auto-detect: use color in non-production environments when the output supports it
 	if c.env == EnvProduction {
 		return false
 	}
-	// Check if output is stdout or stderr and is a terminal
-	if c.output == os.Stdout {
-		return isStdoutTerminal()
-	}
-	if c.output == os.Stderr {
-		return isStderrTerminal()
+	return detectColorDepth(c.output) > ColorDepthNone
+}
+
+// colorDepth returns the color depth of the output, once color output has been chosen.
+// Explicitly enabled color gets at least the basic 16 colors.
+func (c *config) colorDepth() ColorDepth {
+	if depth := detectColorDepth(c.output); depth > ColorDepthNone {
+		return depth
 	}
-	return false
+	return termColorDepth(os.Getenv)
 }
 
 // shouldUseJSON determines if JSON format should be used.
 func (c *config) shouldUseJSON() bool {
+	if c.format != FormatAuto {
+		return c.format == FormatJSON
+	}
 	return c.env == EnvProduction
 }
+
+// leveler returns the minimum level for the configured logger name.
+func (c *config) leveler() slog.Leveler {
+	if level, ok := c.nameLevels[c.name]; ok && c.name != "" {
+		return level
+	}
+	if c.levelProvider != nil {
+		return c.levelProvider(c.name)
+	}
+	return c.level
+}
+
+// buildFlightRecorder returns the flight recorder, or nil if it is disabled.
+func (c *config) buildFlightRecorder() *flightRecorder {
+	if c.flight == nil {
+		return nil
+	}
+	return newFlightRecorder(*c.flight)
+}
+
+// buildSampler returns the sampler for the configured sampling, or nil if sampling is disabled.
+func (c *config) buildSampler() *sampler {
+	if c.sampler != nil {
+		return c.sampler.withFallback(c.sampling)
+	}
+	if c.sampling.enabled() {
+		return newSampler(c.sampling)
+	}
+	return nil
+}
+
+// buildRedactor returns the redactor for the configured keys, or nil if nothing is redacted.
+func (c *config) buildRedactor() *redactor {
+	if c.redactor != nil {
+		var fallback *redactor
+		if len(c.redactKeys) > 0 {
+			fallback = newRedactor(c.redactKeys)
+		}
+		return c.redactor.withFallback(fallback)
+	}
+	if len(c.redactKeys) > 0 {
+		return newRedactor(c.redactKeys)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package xlogging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// openOutput resolves an output name to a writer.
+// The names "stdout" and "stderr" (case-insensitive) select the standard streams;
+// any other value is a file path opened for appending. The returned closer is
+// nil for the standard streams.
+func openOutput(name string) (io.Writer, io.Closer, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "stdout":
+		return os.Stdout, nil, nil
+	case "stderr":
+		return os.Stderr, nil, nil
+	case "":
+		return nil, nil, fmt.Errorf("xlogging: empty output")
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("xlogging: open output: %w", err)
+	}
+	return f, f, nil
+}
+
+// envOutputs caches the files opened for the OUTPUT environment variable,
+// so that loggers created repeatedly share one file descriptor.
+// The files stay open for the life of the process.
+var envOutputs = struct {
+	sync.Mutex
+	writers map[string]io.Writer
+}{
+	writers: make(map[string]io.Writer),
+}
+
+// openEnvOutput resolves an output name like openOutput, reusing files
+// opened by earlier calls.
+func openEnvOutput(name string) (io.Writer, error) {
+	envOutputs.Lock()
+	defer envOutputs.Unlock()
+
+	if w, ok := envOutputs.writers[name]; ok {
+		return w, nil
+	}
+	w, _, err := openOutput(name)
+	if err != nil {
+		return nil, err
+	}
+	envOutputs.writers[name] = w
+	return w, nil
+}
+
+// multiOutput writes every record to all of its writers.
+// Unlike io.MultiWriter it flushes all writers that support it.
+type multiOutput []io.Writer
+
+// Write writes p to all writers, stopping at the first error.
+func (m multiOutput) Write(p []byte) (int, error) {
+	for _, w := range m {
+		if _, err := w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Sync flushes all writers.
+func (m multiOutput) Sync() error {
+	var errs []error
+	for _, w := range m {
+		if err := flushOutput(w); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
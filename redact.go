@@ -0,0 +1,139 @@
+package xlogging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// redactedValue replaces the value of redacted attributes.
+const redactedValue = "[REDACTED]"
+
+// redactor replaces the values of attributes with sensitive keys.
+// Its keys can be replaced at runtime, e.g. by ConfigFile.Reload.
+type redactor struct {
+	keys     *atomic.Pointer[map[string]struct{}] // nil while unset
+	fallback *redactor                            // used while the keys are unset, or nil
+}
+
+// newRedactor creates a redactor for the given keys.
+// Nil keys leave them unset.
+func newRedactor(keys []string) *redactor {
+	r := &redactor{keys: new(atomic.Pointer[map[string]struct{}])}
+	r.set(keys)
+	return r
+}
+
+// withFallback returns a redactor sharing the keys of r that redacts the keys
+// of fallback while they are unset.
+func (r *redactor) withFallback(fallback *redactor) *redactor {
+	return &redactor{keys: r.keys, fallback: fallback}
+}
+
+// set replaces the redacted keys, or unsets them if keys is nil.
+// Keys are matched case-insensitively.
+func (r *redactor) set(keys []string) {
+	if keys == nil {
+		r.keys.Store(nil)
+		return
+	}
+	m := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		m[strings.ToLower(k)] = struct{}{}
+	}
+	r.keys.Store(&m)
+}
+
+// current returns the redacted keys in effect.
+func (r *redactor) current() map[string]struct{} {
+	if keys := r.keys.Load(); keys != nil {
+		return *keys
+	}
+	if r.fallback != nil {
+		return r.fallback.current()
+	}
+	return nil
+}
+
+// active reports whether any keys are redacted.
+func (r *redactor) active() bool {
+	return len(r.current()) > 0
+}
+
+// redact returns the attribute with its value replaced if its key is redacted.
+// Group members are redacted recursively.
+func (r *redactor) redact(a slog.Attr) slog.Attr {
+	return redactKeys(r.current(), a)
+}
+
+// redactKeys returns the attribute with its value replaced if its key is in keys.
+func redactKeys(keys map[string]struct{}, a slog.Attr) slog.Attr {
+	if _, ok := keys[strings.ToLower(a.Key)]; ok {
+		return slog.String(a.Key, redactedValue)
+	}
+
+	v := a.Value.Resolve()
+	if v.Kind() != slog.KindGroup {
+		return a
+	}
+	group := v.Group()
+	redacted := make([]slog.Attr, len(group))
+	for i, ga := range group {
+		redacted[i] = redactKeys(keys, ga)
+	}
+	return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+}
+
+// redactHandler wraps a slog.Handler to redact sensitive attributes.
+type redactHandler struct {
+	inner    slog.Handler
+	redactor *redactor
+}
+
+// newRedactHandler creates a new redactHandler wrapping the given handler.
+func newRedactHandler(inner slog.Handler, r *redactor) *redactHandler {
+	return &redactHandler{
+		inner:    inner,
+		redactor: r,
+	}
+}
+
+// Enabled reports whether the handler handles records at the given level.
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+// Handle handles the record, redacting its attributes.
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.redactor.active() || r.NumAttrs() == 0 {
+		return h.inner.Handle(ctx, r)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.redactor.redact(a))
+		return true
+	})
+	return h.inner.Handle(ctx, redacted)
+}
+
+// WithAttrs returns a new handler with the given attributes.
+// Attributes are redacted with the keys in effect at the time of the call.
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactor.redact(a)
+	}
+	return &redactHandler{
+		inner:    h.inner.WithAttrs(redacted),
+		redactor: h.redactor,
+	}
+}
+
+// WithGroup returns a new handler with the given group name.
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{
+		inner:    h.inner.WithGroup(name),
+		redactor: h.redactor,
+	}
+}
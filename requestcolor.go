@@ -0,0 +1,144 @@
+package xlogging
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// gutterMarker prefixes the lines of a record in the color of its request.
+// Records without a request are prefixed with spaces of the same width.
+const (
+	gutterMarker = "▌ "
+	gutterBlank  = "  "
+)
+
+// maxTagLength is the number of hex digits of the longest request tag.
+const maxTagLength = 8
+
+// RequestColors configures per-request colors of console output. Every record
+// logged with a context holding a value for Key is marked with a color derived
+// from that value, so that all lines of one request are visually grouped among
+// concurrent ones.
+type RequestColors struct {
+	// Key is the context key whose value selects the color. Defaults to KeyRequestID.
+	Key ContextKey
+	// Gutter prefixes every line of the record with a colored marker.
+	// Otherwise only the value of Key, if listed in WithContextKeys, is colored.
+	Gutter bool
+	// Tag renders the value of Key as a hash of this many hex digits (at most 8),
+	// e.g. "#3fa2", instead of the full value. Zero renders the full value.
+	Tag int
+}
+
+// parseRequestColors parses per-request color settings in the form
+// "key=trace_id,gutter,tag=6". The values "on" and "true" enable the defaults;
+// "off", "false" and "none" disable per-request colors and yield nil.
+func parseRequestColors(s string) (*RequestColors, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "off", "false", "none":
+		return nil, nil
+	}
+
+	var rc RequestColors
+	for _, field := range splitList(s) {
+		switch strings.ToLower(field) {
+		case "on", "true":
+			continue
+		case "gutter":
+			rc.Gutter = true
+			continue
+		}
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("xlogging: invalid request colors field %q", field)
+		}
+		val = strings.TrimSpace(val)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "key":
+			rc.Key = ContextKey(val)
+		case "tag":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("xlogging: invalid request colors tag: %w", err)
+			}
+			rc.Tag = n
+		default:
+			return nil, fmt.Errorf("xlogging: unknown request colors field %q", key)
+		}
+	}
+	return &rc, nil
+}
+
+// requestPalette16 holds the colors assigned to requests on 16-color outputs.
+// Red is left out, since it signals errors.
+var requestPalette16 = []Color{
+	ColorGreen, ColorYellow, ColorBlue, ColorMagenta, ColorCyan,
+	"\033[92m", "\033[93m", "\033[94m", "\033[95m", "\033[96m",
+}
+
+// requestPalette256 holds the indexes of the 256-color palette assigned to
+// requests on outputs with more colors: medium-bright, mutually distinct hues.
+var requestPalette256 = []uint8{
+	33, 37, 41, 44, 69, 71, 74, 77, 99, 105, 108, 111, 114, 135, 141, 144,
+	147, 150, 165, 171, 174, 177, 180, 183, 186, 207, 209, 213, 215, 219, 221, 227,
+}
+
+// requestColorizer derives the colors and tags of requests.
+type requestColorizer struct {
+	key    ContextKey
+	gutter bool
+	tag    int
+	colors []string
+}
+
+// newRequestColorizer creates a requestColorizer for the given settings and color depth.
+func newRequestColorizer(rc RequestColors, depth ColorDepth) *requestColorizer {
+	c := &requestColorizer{
+		key:    rc.Key,
+		gutter: rc.Gutter,
+		tag:    min(max(rc.Tag, 0), maxTagLength),
+	}
+	if c.key == "" {
+		c.key = KeyRequestID
+	}
+	if depth >= ColorDepth256 {
+		for _, n := range requestPalette256 {
+			c.colors = append(c.colors, string(Color256(n)))
+		}
+	} else {
+		for _, color := range requestPalette16 {
+			c.colors = append(c.colors, string(color))
+		}
+	}
+	return c
+}
+
+// hash returns the 32-bit FNV-1a hash of a request identifier.
+func (c *requestColorizer) hash(id string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// color returns the color of the request with the given identifier.
+func (c *requestColorizer) color(id string) string {
+	return c.colors[c.hash(id)%uint32(len(c.colors))]
+}
+
+// appendTag renders the identifier, or its tag if tags are enabled.
+func (c *requestColorizer) appendTag(buf []byte, id string) []byte {
+	if c.tag == 0 {
+		return append(buf, id...)
+	}
+	buf = append(buf, '#')
+	sum := c.hash(id)
+	for shift := 28; shift > 28-4*c.tag; shift -= 4 {
+		buf = append(buf, "0123456789abcdef"[sum>>uint(shift)&0xf])
+	}
+	return buf
+}
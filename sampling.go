@@ -0,0 +1,179 @@
+package xlogging
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// samplerSlots is the number of counters used by a sampler.
+// Records are assigned to counters by hashing their level and message,
+// so memory use stays bounded regardless of how many distinct messages are logged.
+const samplerSlots = 4096
+
+// Sampling limits the volume of repetitive log records.
+// Within each Tick interval, the first First records with the same level and
+// message are logged, and thereafter only every Thereafter-th one.
+// Records at LevelError and above are never sampled.
+type Sampling struct {
+	Tick       time.Duration
+	First      int
+	Thereafter int
+}
+
+// parseSampling parses sampling settings in the form
+// "first=100,thereafter=10,tick=1s". Omitted fields are zero.
+// The values "off" and "none" disable sampling and yield nil.
+func parseSampling(s string) (*Sampling, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "off") || strings.EqualFold(s, "none") {
+		return nil, nil
+	}
+
+	var settings Sampling
+	for _, field := range splitList(s) {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("xlogging: invalid sampling field %q", field)
+		}
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "first":
+			settings.First, err = strconv.Atoi(strings.TrimSpace(val))
+		case "thereafter":
+			settings.Thereafter, err = strconv.Atoi(strings.TrimSpace(val))
+		case "tick":
+			settings.Tick, err = time.ParseDuration(strings.TrimSpace(val))
+		default:
+			return nil, fmt.Errorf("xlogging: unknown sampling field %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xlogging: invalid sampling %s: %w", key, err)
+		}
+	}
+	return &settings, nil
+}
+
+// enabled reports whether the sampling settings drop any records.
+func (s *Sampling) enabled() bool {
+	return s != nil && (s.First > 0 || s.Thereafter > 0)
+}
+
+// samplingCounter counts records with the same level and message within a tick.
+type samplingCounter struct {
+	resetAt atomic.Int64
+	n       atomic.Uint64
+}
+
+// sampler decides which records are logged.
+// Its settings can be replaced at runtime, e.g. by ConfigFile.Reload.
+type sampler struct {
+	settings *atomic.Pointer[Sampling] // nil while unset
+	fallback *Sampling                 // used while the settings are unset, or nil
+	counters [samplerSlots]samplingCounter
+}
+
+// newSampler creates a sampler with the given settings.
+// A nil settings value disables sampling.
+func newSampler(settings *Sampling) *sampler {
+	s := &sampler{settings: new(atomic.Pointer[Sampling])}
+	s.set(settings)
+	return s
+}
+
+// withFallback returns a sampler with its own counters sharing the settings
+// of s that samples with fallback while they are unset.
+func (s *sampler) withFallback(fallback *Sampling) *sampler {
+	return &sampler{settings: s.settings, fallback: fallback}
+}
+
+// set replaces the sampling settings, or unsets them if settings is nil.
+func (s *sampler) set(settings *Sampling) {
+	if settings != nil {
+		copied := *settings
+		settings = &copied
+	}
+	s.settings.Store(settings)
+}
+
+// allow reports whether a record with the given level and message should be logged.
+func (s *sampler) allow(level Level, msg string, now time.Time) bool {
+	settings := s.settings.Load()
+	if settings == nil {
+		settings = s.fallback
+	}
+	if !settings.enabled() || level >= LevelError {
+		return true
+	}
+
+	tick := settings.Tick
+	if tick <= 0 {
+		tick = time.Second
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte{byte(level)})
+	h.Write([]byte(msg))
+	counter := &s.counters[h.Sum32()%samplerSlots]
+
+	nanos := now.UnixNano()
+	resetAt := counter.resetAt.Load()
+	if nanos > resetAt && counter.resetAt.CompareAndSwap(resetAt, nanos+int64(tick)) {
+		counter.n.Store(0)
+	}
+
+	n := counter.n.Add(1)
+	first := uint64(max(settings.First, 0))
+	if n <= first {
+		return true
+	}
+	return settings.Thereafter > 0 && (n-first)%uint64(settings.Thereafter) == 0
+}
+
+// samplingHandler wraps a slog.Handler to drop records rejected by a sampler.
+type samplingHandler struct {
+	inner   slog.Handler
+	sampler *sampler
+}
+
+// newSamplingHandler creates a new samplingHandler wrapping the given handler.
+func newSamplingHandler(inner slog.Handler, s *sampler) *samplingHandler {
+	return &samplingHandler{
+		inner:   inner,
+		sampler: s,
+	}
+}
+
+// Enabled reports whether the handler handles records at the given level.
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+// Handle handles the record if the sampler allows it.
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.sampler.allow(r.Level, r.Message, time.Now()) {
+		return nil
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+// WithAttrs returns a new handler with the given attributes.
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{
+		inner:   h.inner.WithAttrs(attrs),
+		sampler: h.sampler,
+	}
+}
+
+// WithGroup returns a new handler with the given group name.
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{
+		inner:   h.inner.WithGroup(name),
+		sampler: h.sampler,
+	}
+}
@@ -0,0 +1,188 @@
+package xlogging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// appendSanitized appends s with control characters, bidirectional formatting
+// characters and invalid UTF-8 escaped Go-style (e.g. \n, \x1b, \u202e), so that
+// user input cannot break a line, forge records or inject terminal escape sequences.
+func appendSanitized(buf []byte, s string) []byte {
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b >= 0x20 && b < 0x7f {
+			buf = append(buf, b)
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			buf = append(buf, `\x`...)
+			buf = appendHex(buf, uint32(b), 2)
+		case r == '\n':
+			buf = append(buf, `\n`...)
+		case r == '\r':
+			buf = append(buf, `\r`...)
+		case r == '\t':
+			buf = append(buf, `\t`...)
+		case r < utf8.RuneSelf:
+			buf = append(buf, `\x`...)
+			buf = appendHex(buf, uint32(r), 2)
+		case unicode.IsControl(r) || isBidiControl(r):
+			buf = append(buf, `\u`...)
+			buf = appendHex(buf, uint32(r), 4)
+		default:
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	return buf
+}
+
+// isBidiControl reports whether r changes the direction of the text that
+// follows it, which can make a line display differently from its content.
+func isBidiControl(r rune) bool {
+	return r == '\u061c' || r == '\u200e' || r == '\u200f' ||
+		(r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// appendHex appends n as lowercase hex with the given number of digits.
+func appendHex(buf []byte, n uint32, digits int) []byte {
+	for shift := 4 * (digits - 1); shift >= 0; shift -= 4 {
+		buf = append(buf, "0123456789abcdef"[n>>uint(shift)&0xf])
+	}
+	return buf
+}
+
+// needsSanitizing reports whether appendSanitized would change s.
+func needsSanitizing(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] >= 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// appendText appends a message, key or other text that is not quoted,
+// sanitized unless the handler writes it verbatim.
+func (h *colorHandler) appendText(buf []byte, s string) []byte {
+	if !h.sanitize {
+		return append(buf, s...)
+	}
+	return appendSanitized(buf, s)
+}
+
+// text returns s sanitized for use in group prefixes and block keys,
+// unless the handler writes text verbatim.
+func (h *colorHandler) text(s string) string {
+	if !h.sanitize || !needsSanitizing(s) {
+		return s
+	}
+	return string(appendSanitized(nil, s))
+}
+
+// textSanitizer wraps slog.TextHandler to escape the DEL character, the only
+// control character it writes verbatim, in messages, keys, group names and values.
+type textSanitizer struct {
+	inner slog.Handler
+}
+
+// Enabled reports whether the handler handles records at the given level.
+func (h *textSanitizer) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+// Handle handles the record, escaping DEL characters if it contains any.
+func (h *textSanitizer) Handle(ctx context.Context, r slog.Record) error {
+	dirty := strings.Contains(r.Message, "\x7f")
+	r.Attrs(func(a slog.Attr) bool {
+		dirty = dirty || containsDEL(a)
+		return !dirty
+	})
+	if !dirty {
+		return h.inner.Handle(ctx, r)
+	}
+
+	clean := slog.NewRecord(r.Time, r.Level, escapeDEL(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(escapeAttrDEL(a))
+		return true
+	})
+	return h.inner.Handle(ctx, clean)
+}
+
+// WithAttrs returns a new handler with the given attributes.
+func (h *textSanitizer) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = escapeAttrDEL(a)
+	}
+	return &textSanitizer{inner: h.inner.WithAttrs(clean)}
+}
+
+// WithGroup returns a new handler with the given group name.
+func (h *textSanitizer) WithGroup(name string) slog.Handler {
+	return &textSanitizer{inner: h.inner.WithGroup(escapeDEL(name))}
+}
+
+// escapeDEL replaces DEL characters by \x7f.
+func escapeDEL(s string) string {
+	return strings.ReplaceAll(s, "\x7f", `\x7f`)
+}
+
+// textValue returns the text slog.TextHandler renders for strings and errors.
+func textValue(v slog.Value) (string, bool) {
+	switch v.Kind() {
+	case slog.KindString:
+		return v.String(), true
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error(), true
+		}
+	}
+	return "", false
+}
+
+// containsDEL reports whether the key or value of a contains a DEL character.
+func containsDEL(a slog.Attr) bool {
+	if strings.Contains(a.Key, "\x7f") {
+		return true
+	}
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			if containsDEL(ga) {
+				return true
+			}
+		}
+		return false
+	}
+	s, ok := textValue(v)
+	return ok && strings.Contains(s, "\x7f")
+}
+
+// escapeAttrDEL escapes DEL characters in the key and value of a, recursing into groups.
+func escapeAttrDEL(a slog.Attr) slog.Attr {
+	if !containsDEL(a) {
+		return a
+	}
+	a.Key = escapeDEL(a.Key)
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		group := a.Value.Group()
+		clean := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			clean[i] = escapeAttrDEL(ga)
+		}
+		a.Value = slog.GroupValue(clean...)
+	} else if s, ok := textValue(a.Value); ok {
+		a.Value = slog.StringValue(escapeDEL(s))
+	}
+	return a
+}
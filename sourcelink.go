@@ -0,0 +1,123 @@
+package xlogging
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Source link URL templates for common editors. {path} is replaced by the
+// absolute, slash-separated path of the source file, which always starts with
+// a slash (e.g. /C:/src/main.go on Windows), and {line} by the line number.
+const (
+	SourceLinkFile   = "file://{path}"
+	SourceLinkVSCode = "vscode://file{path}:{line}"
+	SourceLinkIDEA   = "idea://open?file={path}&line={line}"
+)
+
+// SourceLinks configures how console output renders the source location added by WithSource.
+type SourceLinks struct {
+	// URL is the template of the hyperlink target, e.g. SourceLinkVSCode.
+	// The location is rendered as an OSC 8 terminal hyperlink to it;
+	// an empty URL renders plain text.
+	URL string
+	// ModuleRelative displays paths relative to the root of their Go module
+	// (the nearest directory containing go.mod). Links keep the absolute path.
+	ModuleRelative bool
+}
+
+// parseSourceLinks parses source link settings in the form "vscode,relative".
+// Links are selected by editor name (file, vscode, idea) or by a template
+// containing {path}; "relative" enables module-relative paths.
+func parseSourceLinks(s string) (*SourceLinks, error) {
+	var links SourceLinks
+	for _, field := range splitList(s) {
+		switch strings.ToLower(field) {
+		case "file":
+			links.URL = SourceLinkFile
+		case "vscode":
+			links.URL = SourceLinkVSCode
+		case "idea":
+			links.URL = SourceLinkIDEA
+		case "relative":
+			links.ModuleRelative = true
+		case "off", "none":
+			links.URL = ""
+		default:
+			if !strings.Contains(field, "{path}") {
+				return nil, fmt.Errorf("xlogging: invalid source link %q", field)
+			}
+			links.URL = field
+		}
+	}
+	return &links, nil
+}
+
+// moduleRoots caches the module root of source directories; "" means none was found.
+var moduleRoots sync.Map // map[string]string
+
+// moduleRoot returns the nearest ancestor of dir containing go.mod, or "".
+func moduleRoot(dir string) string {
+	if root, ok := moduleRoots.Load(dir); ok {
+		return root.(string)
+	}
+	root := ""
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			root = d
+			break
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			break
+		}
+		d = parent
+	}
+	moduleRoots.Store(dir, root)
+	return root
+}
+
+// displayPath returns the path of a source file as displayed in console output.
+func (l *SourceLinks) displayPath(file string) string {
+	if !l.ModuleRelative {
+		return file
+	}
+	root := moduleRoot(filepath.Dir(file))
+	if root == "" {
+		return file
+	}
+	if rel, err := filepath.Rel(root, file); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return file
+}
+
+// target returns the hyperlink target of a source location.
+func (l *SourceLinks) target(file string, line int) string {
+	path := filepath.ToSlash(file)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	path = (&url.URL{Path: path}).EscapedPath()
+	return strings.NewReplacer("{path}", path, "{line}", strconv.Itoa(line)).Replace(l.URL)
+}
+
+// appendSourceLocation renders file:line, as a hyperlink if a URL template is set.
+func (l *SourceLinks) appendSourceLocation(buf []byte, file string, line int) []byte {
+	if l.URL != "" {
+		buf = append(buf, "\033]8;;"...)
+		buf = append(buf, l.target(file, line)...)
+		buf = append(buf, "\033\\"...)
+	}
+	buf = append(buf, l.displayPath(file)...)
+	buf = append(buf, ':')
+	buf = strconv.AppendInt(buf, int64(line), 10)
+	if l.URL != "" {
+		buf = append(buf, "\033]8;;\033\\"...)
+	}
+	return buf
+}
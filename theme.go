@@ -0,0 +1,200 @@
+package xlogging
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Text styles. They can be combined with colors by concatenation,
+// e.g. StyleBold + ColorRed.
+const (
+	StyleBold      Color = "\033[1m"
+	StyleDim       Color = "\033[2m"
+	StyleItalic    Color = "\033[3m"
+	StyleUnderline Color = "\033[4m"
+	StyleReverse   Color = "\033[7m"
+)
+
+// Color256 returns the foreground color with the given index in the xterm 256-color palette.
+// It is mapped to the nearest basic color on outputs that support only 16 colors.
+func Color256(n uint8) Color {
+	return Color(fmt.Sprintf("\033[38;5;%dm", n))
+}
+
+// RGB returns a 24-bit foreground color.
+// It is mapped to the nearest palette color on outputs without truecolor support.
+func RGB(r, g, b uint8) Color {
+	return Color(fmt.Sprintf("\033[38;2;%d;%d;%dm", r, g, b))
+}
+
+// Theme defines the colors of console output.
+// Empty fields are rendered without color.
+type Theme struct {
+	Time      Color           // leading timestamp
+	Levels    map[Level]Color // level names; levels not listed use their registered color
+	Message   Color           // log message
+	Key       Color           // attribute keys
+	Context   Color           // values extracted from the context
+	String    Color           // string values
+	Number    Color           // integer and floating-point values
+	Bool      Color           // boolean values
+	Duration  Color           // time.Duration values
+	TimeValue Color           // time.Time values
+	Nil       Color           // nil values
+	Any       Color           // values of any other kind
+	Error     Color           // error values
+	Source    Color           // source code location
+	Highlight Color           // keys and values selected by WithHighlightKeys and WithHighlightValues
+}
+
+// DarkTheme returns the default theme, designed for dark terminal backgrounds.
+func DarkTheme() Theme {
+	return Theme{
+		Time:      ColorGray,
+		Message:   StyleBold,
+		Key:       ColorCyan,
+		Context:   ColorGray,
+		Bool:      ColorYellow,
+		Error:     ColorRed,
+		Source:    ColorGray,
+		Nil:       ColorGray + StyleItalic,
+		Highlight: StyleReverse + StyleBold,
+	}
+}
+
+// LightTheme returns a theme designed for light terminal backgrounds.
+func LightTheme() Theme {
+	return Theme{
+		Time: Color256(244),
+		Levels: map[Level]Color{
+			LevelInfo:   Color256(28),
+			LevelNotice: Color256(30),
+			LevelWarn:   Color256(130),
+		},
+		Message:   StyleBold,
+		Key:       Color256(25),
+		Context:   Color256(244),
+		String:    Color256(22),
+		Number:    Color256(90),
+		Bool:      Color256(130),
+		Duration:  Color256(90),
+		Nil:       Color256(244),
+		Error:     Color256(160),
+		Source:    Color256(244),
+		Highlight: StyleReverse + StyleBold,
+	}
+}
+
+// MonochromeTheme returns a theme that uses only text styles and no colors.
+func MonochromeTheme() Theme {
+	return Theme{
+		Levels: map[Level]Color{
+			LevelTrace:    StyleDim,
+			LevelDebug:    StyleDim,
+			LevelInfo:     ColorNone,
+			LevelNotice:   ColorNone,
+			LevelWarn:     StyleUnderline,
+			LevelError:    StyleReverse,
+			LevelCritical: StyleReverse,
+			LevelPanic:    StyleReverse,
+			LevelFatal:    StyleReverse,
+		},
+		Message:   StyleBold,
+		Context:   StyleDim,
+		Time:      StyleDim,
+		Source:    StyleDim,
+		Bool:      StyleItalic,
+		Nil:       StyleDim + StyleItalic,
+		Error:     StyleUnderline,
+		Highlight: StyleReverse,
+	}
+}
+
+// parseTheme returns the built-in theme with the given name (case-insensitive).
+func parseTheme(name string) (Theme, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "dark", "":
+		return DarkTheme(), nil
+	case "light":
+		return LightTheme(), nil
+	case "mono", "monochrome":
+		return MonochromeTheme(), nil
+	default:
+		return Theme{}, fmt.Errorf("xlogging: unknown theme %q", name)
+	}
+}
+
+// palette holds the escape sequences of a Theme converted to an output's color depth.
+type palette struct {
+	time, message, key, context, source, highlight string
+	str, number, boolean, duration, timeValue      string
+	nilValue, anyValue, err                        string
+	levels                                         map[Level]string
+	depth                                          ColorDepth
+}
+
+// newPalette converts the theme to the given color depth.
+func newPalette(t Theme, depth ColorDepth) *palette {
+	c := func(color Color) string {
+		return string(color.forDepth(depth))
+	}
+	p := &palette{
+		time:      c(t.Time),
+		message:   c(t.Message),
+		key:       c(t.Key),
+		context:   c(t.Context),
+		source:    c(t.Source),
+		highlight: c(t.Highlight),
+		str:       c(t.String),
+		number:    c(t.Number),
+		boolean:   c(t.Bool),
+		duration:  c(t.Duration),
+		timeValue: c(t.TimeValue),
+		nilValue:  c(t.Nil),
+		anyValue:  c(t.Any),
+		err:       c(t.Error),
+		levels:    make(map[Level]string, len(t.Levels)),
+		depth:     depth,
+	}
+	for level, color := range t.Levels {
+		p.levels[level] = c(color)
+	}
+	return p
+}
+
+// level returns the color of the given level.
+// Themes override the registered color of the closest registered level.
+func (p *palette) level(level Level) string {
+	if color, ok := p.levels[lookupLevel(level).level]; ok {
+		return color
+	}
+	return string(levelColor(level).forDepth(p.depth))
+}
+
+// value returns the color of the given value.
+func (p *palette) value(v slog.Value) string {
+	switch v.Kind() {
+	case slog.KindString:
+		return p.str
+	case slog.KindInt64, slog.KindUint64, slog.KindFloat64:
+		return p.number
+	case slog.KindBool:
+		return p.boolean
+	case slog.KindDuration:
+		return p.duration
+	case slog.KindTime:
+		return p.timeValue
+	default:
+		switch v.Any().(type) {
+		case nil:
+			return p.nilValue
+		case Bytes:
+			return p.number
+		case error:
+			return p.err
+		default:
+			return p.anyValue
+		}
+	}
+}
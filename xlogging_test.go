@@ -3,9 +3,25 @@ package xlogging
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/slogtest"
+	"time"
+	"unicode/utf8"
 )
 
 func TestParseLevel(t *testing.T) {
@@ -22,6 +38,11 @@ func TestParseLevel(t *testing.T) {
 		{"warning", LevelWarn},
 		{"error", LevelError},
 		{"ERROR", LevelError},
+		{"trace", LevelTrace},
+		{"Notice", LevelNotice},
+		{"critical", LevelCritical},
+		{"panic", LevelPanic},
+		{"FATAL", LevelFatal},
 		{"unknown", LevelInfo}, // default
 		{"", LevelInfo},        // default
 	}
@@ -36,6 +57,181 @@ func TestParseLevel(t *testing.T) {
 	}
 }
 
+func TestParseLevelStrict(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Level
+		wantErr  bool
+	}{
+		{"debug", LevelDebug, false},
+		{" Warning ", LevelWarn, false},
+		{"info+2", LevelInfo + 2, false},
+		{"DEBUG-1", LevelDebug - 1, false},
+		{"-4", LevelDebug, false},
+		{"12", LevelPanic, false},
+		{"debgu", 0, true},
+		{"info+x", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseLevelStrict(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevelStrict(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("ParseLevelStrict(%q) = %v, want %v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLevelFlag(t *testing.T) {
+	var f LevelFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(&f, "level", "log level")
+
+	if err := fs.Parse([]string{"-level", "warn+1"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if f.Level() != LevelWarn+1 {
+		t.Errorf("Level() = %v, want %v", f.Level(), LevelWarn+1)
+	}
+	if err := fs.Parse([]string{"-level", "debgu"}); err == nil {
+		t.Error("Parse() should fail for invalid level")
+	}
+
+	var cfg struct {
+		Level   LevelFlag `json:"level"`
+		Numeric LevelFlag `json:"numeric"`
+	}
+	if err := json.Unmarshal([]byte(`{"level":"notice","numeric":-4}`), &cfg); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if cfg.Level != LevelFlag(LevelNotice) || cfg.Numeric != LevelFlag(LevelDebug) {
+		t.Errorf("unmarshaled = %v, %v, want NOTICE, DEBUG", cfg.Level, cfg.Numeric)
+	}
+	if err := json.Unmarshal([]byte(`{"level":"debgu"}`), &cfg); err == nil {
+		t.Error("json.Unmarshal() should fail for invalid level")
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if string(data) != `{"level":"NOTICE","numeric":"DEBUG"}` {
+		t.Errorf("json.Marshal() = %s", data)
+	}
+
+	err = f.UnmarshalYAML(func(v any) error {
+		*(v.(*string)) = "error"
+		return nil
+	})
+	if err != nil || f.Level() != LevelError {
+		t.Errorf("UnmarshalYAML() = %v, %v, want ERROR, nil", f, err)
+	}
+}
+
+func TestDetectLevelInvalid(t *testing.T) {
+	t.Setenv("XLOG_LEVEL", "debgu")
+	t.Setenv("XLOG_ENV", "production")
+
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithLevel(LevelError))
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if entry["level"] != "WARN" {
+		t.Errorf("level = %v, want %q", entry["level"], "WARN")
+	}
+	if errStr, _ := entry["error"].(string); !strings.Contains(errStr, "debgu") {
+		t.Errorf("error = %v, want mention of the invalid value", entry["error"])
+	}
+
+	buf.Reset()
+	log.Info("filtered")
+	if buf.Len() != 0 {
+		t.Errorf("info message should be filtered, got %q", buf.String())
+	}
+}
+
+func TestLevelName(t *testing.T) {
+	tests := []struct {
+		level    Level
+		expected string
+	}{
+		{LevelTrace, "TRACE"},
+		{LevelDebug, "DEBUG"},
+		{LevelInfo, "INFO"},
+		{LevelNotice, "NOTICE"},
+		{LevelInfo + 1, "INFO+1"},
+		{LevelCritical, "CRITICAL"},
+		{LevelTrace - 2, "TRACE-2"},
+		{LevelFatal + 4, "FATAL+4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := levelName(tt.level); got != tt.expected {
+				t.Errorf("levelName(%d) = %q, want %q", tt.level, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRegisterLevel(t *testing.T) {
+	const levelAudit = Level(6)
+	RegisterLevel(levelAudit, "audit", ColorCyan)
+
+	if got := ParseLevel("AUDIT"); got != levelAudit {
+		t.Errorf("ParseLevel(%q) = %v, want %v", "AUDIT", got, levelAudit)
+	}
+	if got := levelColor(levelAudit + 1); got != ColorCyan {
+		t.Errorf("levelColor(%d) = %q, want %q", levelAudit+1, got, ColorCyan)
+	}
+
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvProduction),
+	)
+	log.Handler().Handle(context.Background(), slog.NewRecord(time.Now(), levelAudit, "audit message", 0))
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if entry["level"] != "AUDIT" {
+		t.Errorf("level = %v, want %q", entry["level"], "AUDIT")
+	}
+}
+
+func TestColorHandlerCustomLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithLevel(LevelTrace),
+		WithColor(true),
+	)
+
+	ctx := context.Background()
+	log.Handler().Handle(ctx, slog.NewRecord(time.Now(), LevelTrace, "trace message", 0))
+	log.Handler().Handle(ctx, slog.NewRecord(time.Now(), LevelNotice, "notice message", 0))
+	log.Handler().Handle(ctx, slog.NewRecord(time.Now(), LevelInfo+1, "offset message", 0))
+
+	output := buf.String()
+	for _, want := range []string{"TRACE", "NOTICE", "INFO+1"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output should contain %q, got %q", want, output)
+		}
+	}
+}
+
 func TestDefaultLevelForEnv(t *testing.T) {
 	tests := []struct {
 		env      Env
@@ -156,6 +352,128 @@ func TestLoggerWithGroup(t *testing.T) {
 	}
 }
 
+// syncBuffer is a bytes.Buffer that records Sync calls.
+type syncBuffer struct {
+	bytes.Buffer
+	synced int
+}
+
+func (b *syncBuffer) Sync() error {
+	b.synced++
+	return nil
+}
+
+func TestLoggerFatal(t *testing.T) {
+	var buf syncBuffer
+	exitCode := -1
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvProduction),
+		WithExitFunc(func(code int) { exitCode = code }),
+	)
+
+	log.Fatal("fatal message", "key", "value")
+
+	if exitCode != 1 {
+		t.Errorf("exit code = %d, want 1", exitCode)
+	}
+	if buf.synced != 1 {
+		t.Errorf("Sync() called %d times, want 1", buf.synced)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if entry["level"] != "FATAL" {
+		t.Errorf("level = %v, want %q", entry["level"], "FATAL")
+	}
+	if entry["key"] != "value" {
+		t.Errorf("key = %v, want %q", entry["key"], "value")
+	}
+}
+
+func TestWithExitFuncNil(t *testing.T) {
+	log := New(WithOutput(io.Discard), WithExitFunc(nil)).(*logger)
+	if log.exit == nil {
+		t.Error("WithExitFunc(nil) should restore os.Exit")
+	}
+}
+
+func TestLoggerPanic(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(false),
+	)
+
+	defer func() {
+		if r := recover(); r != "panic message" {
+			t.Errorf("recover() = %v, want %q", r, "panic message")
+		}
+		if !strings.Contains(buf.String(), "level=PANIC") {
+			t.Errorf("output = %q, want level=PANIC", buf.String())
+		}
+	}()
+
+	log.PanicContext(context.Background(), "panic message")
+	t.Error("PanicContext should not return")
+}
+
+func TestColorHandlerFatalLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithExitFunc(func(int) {}),
+	)
+
+	log.Fatal("fatal message")
+
+	if !strings.Contains(buf.String(), "FATAL") {
+		t.Errorf("output = %q, want FATAL level", buf.String())
+	}
+}
+
+func TestLoggerSource(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvProduction),
+		WithSource(true),
+	)
+
+	log.Info("source message")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	source, ok := entry["source"].(map[string]any)
+	if !ok {
+		t.Fatal("source not found")
+	}
+	if file, _ := source["file"].(string); !strings.HasSuffix(file, "xlogging_test.go") {
+		t.Errorf("source.file = %v, want xlogging_test.go", source["file"])
+	}
+
+	buf.Reset()
+	log = New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithSource(true),
+	)
+
+	log.Info("source")
+
+	if file, _ := parseColorLine(t, buf.String())[slog.SourceKey].(string); !strings.Contains(file, "xlogging_test.go:") {
+		t.Errorf("color source = %q, want xlogging_test.go:<line>", file)
+	}
+}
+
 func TestContextHandler(t *testing.T) {
 	var buf bytes.Buffer
 	log := New(
@@ -205,104 +523,733 @@ func TestContextHelpers(t *testing.T) {
 	}
 }
 
-func TestColorHandler(t *testing.T) {
-	var buf bytes.Buffer
-	log := New(
-		WithOutput(&buf),
-		WithLevel(LevelDebug),
-		WithEnv(EnvDevelopment),
-		WithColor(true),
-	)
+// tenantID is a fixed-size identifier rendered in hex, like a UUID.
+type tenantID [4]byte
 
-	log.Debug("debug message")
-	log.Info("info message")
-	log.Warn("warn message")
-	log.Error("error message")
+func (id tenantID) String() string { return hex.EncodeToString(id[:]) }
 
-	output := buf.String()
-	if !strings.Contains(output, "debug message") {
-		t.Error("debug message should be present")
+// principal is a LogValuer context value.
+type principal struct{ name string }
+
+func (p *principal) LogValue() slog.Value { return slog.StringValue(p.name) }
+
+func TestTypedContextKeys(t *testing.T) {
+	tenantKey := NewContextKey[tenantID]("tenant_id")
+	shardKey := NewContextKey[int]("shard")
+	principalKey := NewContextKey[*principal]("principal")
+
+	ctx := context.Background()
+	ctx = tenantKey.With(ctx, tenantID{0xde, 0xad, 0xbe, 0xef})
+	ctx = shardKey.With(ctx, 7)
+	ctx = principalKey.With(ctx, &principal{name: "jane"})
+
+	if v, ok := shardKey.Get(ctx); !ok || v != 7 {
+		t.Errorf("shardKey.Get() = %v, %v, want 7, true", v, ok)
 	}
-	if !strings.Contains(output, "info message") {
-		t.Error("info message should be present")
+	if _, ok := NewContextKey[string]("shard").Get(ctx); ok {
+		t.Error("Get() with a different type should report false")
 	}
-	if !strings.Contains(output, "warn message") {
-		t.Error("warn message should be present")
+
+	keys := []ContextKey{tenantKey.Key(), shardKey.Key(), principalKey.Key(), KeyRequestID}
+	want := map[string]any{"tenant_id": "deadbeef", "shard": "7", "principal": "jane"}
+
+	var buf bytes.Buffer
+	New(WithOutput(&buf), WithEnv(EnvProduction), WithContextKeys(keys...)).InfoContext(ctx, "typed")
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
 	}
-	if !strings.Contains(output, "error message") {
-		t.Error("error message should be present")
+	for key, value := range want {
+		if got := fmt.Sprint(entry[key]); got != value {
+			t.Errorf("json %s = %v, want %v", key, got, value)
+		}
+	}
+	if _, ok := entry["request_id"]; ok {
+		t.Error("json request_id should be absent")
 	}
 
-	// Check that ANSI codes are present
-	if !strings.Contains(output, "\033[") {
-		t.Error("ANSI color codes should be present")
+	buf.Reset()
+	New(WithOutput(&buf), WithColor(true), WithContextKeys(keys...)).InfoContext(ctx, "typed")
+	fields := parseColorLine(t, buf.String())
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("color %s = %v, want %v", key, fields[key], value)
+		}
 	}
 }
 
-func TestTextHandler(t *testing.T) {
-	var buf bytes.Buffer
-	log := New(
-		WithOutput(&buf),
-		WithLevel(LevelInfo),
-		WithEnv(EnvDevelopment),
-		WithColor(false),
-	)
+func TestContextExtractor(t *testing.T) {
+	principalKey := NewContextKey[*principal]("principal")
+	extract := func(ctx context.Context) []slog.Attr {
+		p, ok := principalKey.Get(ctx)
+		if !ok {
+			return nil
+		}
+		return []slog.Attr{slog.Group("auth", slog.String("name", p.name), slog.Bool("admin", true))}
+	}
+	ctx := WithRequestID(principalKey.With(context.Background(), &principal{name: "jane"}), "req-1")
 
-	log.Info("text message", "key", "value")
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvProduction), WithContextKeys(KeyRequestID), WithContextExtractor(extract))
+	log.InfoContext(ctx, "extracted")
+	log.InfoContext(context.Background(), "plain")
 
-	output := buf.String()
-	if !strings.Contains(output, "text message") {
-		t.Error("message should be present")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
 	}
-	if !strings.Contains(output, "key=value") {
-		t.Error("attribute should be present")
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	auth, _ := entry["auth"].(map[string]any)
+	if entry["request_id"] != "req-1" || auth["name"] != "jane" || auth["admin"] != true {
+		t.Errorf("json = %v, want request_id and auth from context", entry)
+	}
+	if strings.Contains(lines[1], "auth") {
+		t.Errorf("json without principal = %s, want no auth", lines[1])
+	}
+
+	buf.Reset()
+	log = New(WithOutput(&buf), WithColor(true), WithContextExtractor(extract))
+	log.InfoContext(ctx, "extracted", "k", "v")
+	fields := parseColorLine(t, buf.String())
+	auth, _ = fields["auth"].(map[string]any)
+	if fields["k"] != "v" || auth["name"] != "jane" || auth["admin"] != "true" {
+		t.Errorf("color = %v, want k and auth from context", fields)
 	}
 }
 
-func TestTestLogger(t *testing.T) {
-	log := NewTestLogger()
+func TestAppendCtx(t *testing.T) {
+	base := AppendCtx(context.Background(), "tenant", "acme")
+	ctx := AppendCtx(base, slog.Int("attempt", 2), "route", "/orders")
+	sibling := AppendCtx(base, "route", "/users")
 
-	log.Debug("debug")
-	log.Info("info")
-	log.Warn("warn")
-	log.Error("error")
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvProduction))
+	log.InfoContext(ctx, "child")
+	log.InfoContext(sibling, "sibling")
+	log.InfoContext(base, "base")
 
-	if log.Len() != 4 {
-		t.Errorf("Len() = %d, want 4", log.Len())
+	want := []map[string]any{
+		{"tenant": "acme", "attempt": float64(2), "route": "/orders"},
+		{"tenant": "acme", "route": "/users"},
+		{"tenant": "acme"},
 	}
-
-	if !log.HasEntry(LevelDebug, "debug") {
-		t.Error("should have debug entry")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
 	}
-	if !log.HasEntry(LevelInfo, "info") {
-		t.Error("should have info entry")
+	for i, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to parse JSON: %v", err)
+		}
+		for _, key := range []string{"tenant", "attempt", "route"} {
+			if entry[key] != want[i][key] {
+				t.Errorf("line %d: %s = %v, want %v", i, key, entry[key], want[i][key])
+			}
+		}
 	}
-	if !log.HasEntry(LevelWarn, "warn") {
-		t.Error("should have warn entry")
+
+	buf.Reset()
+	New(WithOutput(&buf), WithColor(true)).With("k", "v").InfoContext(ctx, "child")
+	fields := parseColorLine(t, buf.String())
+	if fields["k"] != "v" || fields["tenant"] != "acme" || fields["attempt"] != "2" || fields["route"] != "/orders" {
+		t.Errorf("color = %v, want k and context attributes", fields)
 	}
-	if !log.HasEntry(LevelError, "error") {
-		t.Error("should have error entry")
+}
+
+func TestLoggerInContext(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvProduction), WithContextKeys(KeyRequestID))
+
+	ctx := IntoContext(context.Background(), log.With("component", "billing"))
+	ctx = WithRequestID(ctx, "req-42")
+	FromContext(ctx).Info("charged")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if entry["component"] != "billing" || entry["request_id"] != "req-42" {
+		t.Errorf("entry = %v, want component and request_id", entry)
 	}
 
-	if log.Count(LevelInfo) != 1 {
-		t.Errorf("Count(LevelInfo) = %d, want 1", log.Count(LevelInfo))
+	t.Cleanup(func() { SetFallback(nil) })
+	var nilCtx context.Context
+	if FromContext(context.Background()) == nil || FromContext(nilCtx) == nil {
+		t.Fatal("FromContext() without a logger = nil, want the default logger")
+	}
+	fallback := NewTestLogger()
+	SetFallback(fallback)
+	FromContext(context.Background()).Info("fallback")
+	if !fallback.HasEntry(LevelInfo, "fallback") {
+		t.Error("FromContext() without a logger should return the fallback logger")
 	}
 }
 
-func TestTestLoggerWithAttrs(t *testing.T) {
-	log := NewTestLogger()
+func TestLoggerWithContext(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvProduction), WithContextKeys(KeyRequestID))
 
-	log.Info("message", "key", "value")
+	bound := log.WithContext(WithRequestID(context.Background(), "req-bound"))
+	bound.With("k", "v").Info("bound")
+	bound.InfoContext(WithRequestID(context.Background(), "req-explicit"), "explicit")
+	log.Info("unbound")
 
-	if !log.HasEntryWithAttr(LevelInfo, "message", "key", "value") {
-		t.Error("should have entry with attribute")
+	want := []any{"req-bound", "req-explicit", nil}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to parse JSON: %v", err)
+		}
+		if entry["request_id"] != want[i] {
+			t.Errorf("line %d: request_id = %v, want %v", i, entry["request_id"], want[i])
+		}
 	}
 }
 
-func TestTestLoggerWith(t *testing.T) {
-	log := NewTestLogger()
+func TestContextOutput(t *testing.T) {
+	ctx := AppendCtx(WithTraceID(context.Background(), "abc"), "tenant", "acme")
+	output := ContextOutput{Names: map[ContextKey]string{KeyTraceID: "trace"}}
 
-	childLog := log.With("service", "api")
+	tests := []struct {
+		name     string
+		group    string
+		conflict ContextConflict
+		want     map[string]any // expected JSON entry, without time, level and msg
+	}{
+		{"keep both", "", ContextConflictKeepBoth, map[string]any{"trace": "abc", "tenant": "acme"}},
+		{"prefer record", "", ContextConflictPreferRecord, map[string]any{"trace": "abc", "tenant": "record"}},
+		{"prefer context", "", ContextConflictPreferContext, map[string]any{"trace": "abc", "tenant": "acme"}},
+		{"group", "ctx", ContextConflictPreferRecord, map[string]any{
+			"tenant": "record",
+			"ctx":    map[string]any{"trace": "abc", "tenant": "acme"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := output
+			o.Group, o.Conflict = tt.group, tt.conflict
+
+			var buf bytes.Buffer
+			log := New(WithOutput(&buf), WithEnv(EnvProduction), WithContextKeys(KeyTraceID), WithContextOutput(o))
+			log.InfoContext(ctx, "msg", "tenant", "record")
+
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("failed to parse JSON: %v", err)
+			}
+			delete(entry, slog.TimeKey)
+			delete(entry, slog.LevelKey)
+			delete(entry, slog.MessageKey)
+			if tt.conflict == ContextConflictKeepBoth {
+				// Duplicate keys: the JSON decoder keeps the last one
+				if n := strings.Count(buf.String(), `"tenant"`); n != 2 {
+					t.Errorf("tenant appears %d times, want 2", n)
+				}
+			}
+			if !reflect.DeepEqual(entry, tt.want) {
+				t.Errorf("json = %v, want %v", entry, tt.want)
+			}
+
+			buf.Reset()
+			log = New(WithOutput(&buf), WithColor(true), WithContextKeys(KeyTraceID), WithContextOutput(o))
+			log.InfoContext(ctx, "msg", "tenant", "record")
+			fields := parseColorLine(t, buf.String())
+			for _, key := range []string{slog.TimeKey, slog.LevelKey, slog.MessageKey} {
+				delete(fields, key)
+			}
+			if tt.conflict == ContextConflictKeepBoth {
+				// parseColorLine keeps the last one as well
+				if n := strings.Count(ansiPattern.ReplaceAllString(buf.String(), ""), " tenant="); n != 2 {
+					t.Errorf("color tenant appears %d times, want 2", n)
+				}
+			}
+			if !reflect.DeepEqual(fields, tt.want) {
+				t.Errorf("color = %v, want %v", fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextExtractorCalledOnce(t *testing.T) {
+	for _, conflict := range []ContextConflict{ContextConflictKeepBoth, ContextConflictPreferRecord, ContextConflictPreferContext} {
+		calls := 0
+		extract := func(ctx context.Context) []slog.Attr {
+			calls++
+			return []slog.Attr{slog.Int("calls", calls)}
+		}
+		log := New(WithOutput(io.Discard), WithColor(true),
+			WithContextExtractor(extract), WithContextOutput(ContextOutput{Conflict: conflict}))
+		log.InfoContext(context.Background(), "msg", "k", "v")
+		if calls != 1 {
+			t.Errorf("conflict %d: extractor called %d times, want 1", conflict, calls)
+		}
+	}
+}
+
+func TestParseContextOutput(t *testing.T) {
+	got, err := parseContextOutput("trace_id=trace.id, group=ctx, conflict=record")
+	if err != nil {
+		t.Fatalf("parseContextOutput() error = %v", err)
+	}
+	want := &ContextOutput{Names: map[ContextKey]string{KeyTraceID: "trace.id"}, Group: "ctx", Conflict: ContextConflictPreferRecord}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseContextOutput() = %+v, want %+v", got, want)
+	}
+	for _, s := range []string{"trace_id", "conflict=never"} {
+		if _, err := parseContextOutput(s); err == nil {
+			t.Errorf("parseContextOutput(%q) error = nil, want error", s)
+		}
+	}
+}
+
+func TestLevelOverride(t *testing.T) {
+	for _, color := range []bool{false, true} {
+		var buf bytes.Buffer
+		log := New(WithOutput(&buf), WithLevel(LevelInfo), WithColor(color))
+
+		debugCtx := WithLevelOverride(context.Background(), LevelDebug)
+		quietCtx := WithLevelOverride(context.Background(), LevelWarn)
+		log.DebugContext(debugCtx, "debug with override")
+		log.WithContext(debugCtx).Debug("debug bound")
+		log.Debug("debug without override")
+		log.InfoContext(quietCtx, "info quiet")
+		log.WarnContext(quietCtx, "warn quiet")
+
+		out := buf.String()
+		for _, msg := range []string{"debug with override", "debug bound", "warn quiet"} {
+			if !strings.Contains(out, msg) {
+				t.Errorf("color=%v: %q should be logged", color, msg)
+			}
+		}
+		for _, msg := range []string{"debug without override", "info quiet"} {
+			if strings.Contains(out, msg) {
+				t.Errorf("color=%v: %q should not be logged", color, msg)
+			}
+		}
+	}
+}
+
+func TestFlightRecorder(t *testing.T) {
+	messages := func(buf *bytes.Buffer) []string {
+		var msgs []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("failed to parse JSON: %v", err)
+			}
+			msgs = append(msgs, entry[slog.MessageKey].(string))
+		}
+		return msgs
+	}
+
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvProduction), WithLevel(LevelInfo),
+		WithContextKeys(KeyRequestID), WithFlightRecorder(FlightRecorder{Records: 2}))
+	a := WithRequestID(context.Background(), "a")
+	b := WithRequestID(context.Background(), "b")
+
+	log.DebugContext(a, "a1")
+	log.DebugContext(b, "b1")
+	log.DebugContext(a, "a2")
+	log.InfoContext(a, "a info")
+	log.With("step", 3).DebugContext(a, "a3")
+	log.ErrorContext(a, "a failed")
+	log.ErrorContext(a, "a failed again")
+
+	want := []string{"a info", "a2", "a3", "a failed", "a failed again"}
+	if got := messages(&buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+	if !strings.Contains(buf.String(), `"step":3`) || !strings.Contains(buf.String(), `"request_id":"a"`) {
+		t.Errorf("replayed records should keep their attributes and context values: %s", buf.String())
+	}
+
+	// The least recently active request is evicted
+	buf.Reset()
+	log = New(WithOutput(&buf), WithEnv(EnvProduction), WithLevel(LevelInfo),
+		WithFlightRecorder(FlightRecorder{Requests: 1}))
+	log.DebugContext(a, "a1")
+	log.DebugContext(b, "b1")
+	log.Debug("global")
+	log.ErrorContext(b, "b failed")
+	log.ErrorContext(a, "a failed")
+	log.Error("failed")
+
+	want = []string{"b failed", "a failed", "global", "failed"}
+	if got := messages(&buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+
+	// Records below the recorder's level are dropped
+	buf.Reset()
+	log = New(WithOutput(&buf), WithEnv(EnvProduction), WithLevel(LevelWarn),
+		WithFlightRecorder(FlightRecorder{Level: LevelInfo}))
+	if log.Handler().Enabled(context.Background(), LevelDebug) {
+		t.Error("levels below the recorder's level should not be enabled")
+	}
+	log.Debug("dropped")
+	log.Info("buffered")
+	log.Error("failed")
+
+	want = []string{"buffered", "failed"}
+	if got := messages(&buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+}
+
+func TestParseFlightRecorder(t *testing.T) {
+	if got, err := parseFlightRecorder("records=50, requests=10"); err != nil || *got != (FlightRecorder{Records: 50, Requests: 10}) {
+		t.Errorf("parseFlightRecorder() = %+v, %v", got, err)
+	}
+	if got, err := parseFlightRecorder("on,level=trace"); err != nil || *got != (FlightRecorder{Level: LevelTrace}) {
+		t.Errorf("parseFlightRecorder(on,level=trace) = %+v, %v", got, err)
+	}
+	if got, err := parseFlightRecorder("off"); err != nil || got != nil {
+		t.Errorf("parseFlightRecorder(off) = %+v, %v, want nil", got, err)
+	}
+	if _, err := parseFlightRecorder("records=many"); err == nil {
+		t.Error("parseFlightRecorder(records=many) error = nil, want error")
+	}
+}
+
+func TestBufferRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []BufferOption
+		failed bool
+		want   []string
+	}{
+		{"succeeded", nil, false, []string{"info"}},
+		{"failed", nil, true, []string{"debug", "info", "debug2"}},
+		{"sampled", []BufferOption{BufferSampleRate(1)}, false, []string{"debug", "info", "debug2"}},
+		{"limit", []BufferOption{BufferLimit(2)}, true, []string{"info", "debug2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := New(WithOutput(&buf), WithEnv(EnvProduction), WithLevel(LevelInfo), WithContextKeys(KeyRequestID))
+
+			ctx, commit := BufferRequest(WithRequestID(context.Background(), "req-1"), tt.opts...)
+			logger.DebugContext(ctx, "debug")
+			logger.WithContext(ctx).Info("info")
+			logger.DebugContext(ctx, "debug2")
+			if buf.Len() != 0 {
+				t.Fatalf("records were written before commit: %s", buf.String())
+			}
+			commit(tt.failed)
+			commit(true) // no-op
+			logger.DebugContext(ctx, "after commit")
+			logger.InfoContext(ctx, "direct")
+
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var entry map[string]any
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("failed to parse JSON: %v", err)
+				}
+				if entry["request_id"] != "req-1" {
+					t.Errorf("%q: request_id = %v, want req-1", entry[slog.MessageKey], entry["request_id"])
+				}
+				got = append(got, entry[slog.MessageKey].(string))
+			}
+			if want := append(tt.want, "direct"); !reflect.DeepEqual(got, want) {
+				t.Errorf("messages = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBufferRequestLimit(t *testing.T) {
+	for _, failed := range []bool{false, true} {
+		var buf bytes.Buffer
+		logger := New(WithOutput(&buf), WithEnv(EnvProduction), WithLevel(LevelInfo))
+
+		ctx, commit := BufferRequest(context.Background(), BufferLimit(2))
+		logger.DebugContext(ctx, "debug1")
+		logger.InfoContext(ctx, "info")
+		logger.DebugContext(ctx, "debug2")
+		logger.DebugContext(ctx, "debug3") // evicts info, which is logged
+		commit(failed)
+
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("failed to parse JSON: %v", err)
+			}
+			got = append(got, entry[slog.MessageKey].(string))
+		}
+		want := []string{"info"}
+		if failed {
+			want = append(want, "debug2", "debug3")
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("failed=%v: messages = %v, want %v", failed, got, want)
+		}
+	}
+}
+
+func TestBufferRequestFlightRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(WithOutput(&buf), WithEnv(EnvProduction), WithLevel(LevelInfo), WithFlightRecorder(FlightRecorder{}))
+
+	ctx, commit := BufferRequest(context.Background())
+	logger.DebugContext(ctx, "debug")
+	logger.InfoContext(ctx, "info")
+	commit(true)
+
+	for _, want := range []string{`"msg":"debug"`, `"msg":"info"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output should contain %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+// reentrantValue logs with its context when it is resolved.
+type reentrantValue struct {
+	log Logger
+	ctx context.Context
+}
+
+func (v reentrantValue) LogValue() slog.Value {
+	v.log.InfoContext(v.ctx, "nested")
+	return slog.StringValue("outer")
+}
+
+func TestBufferRequestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(WithOutput(&buf), WithEnv(EnvProduction), WithLevel(LevelInfo))
+
+	ctx, commit := BufferRequest(context.Background())
+	sl := slog.New(logger.Handler())
+	sl.DebugContext(ctx, "debug")
+	sl.InfoContext(ctx, "info")
+	if buf.Len() != 0 {
+		t.Fatalf("records were written before commit: %s", buf.String())
+	}
+	commit(true)
+	if got := strings.Count(buf.String(), "\n"); got != 2 {
+		t.Errorf("got %d lines after commit, want 2:\n%s", got, buf.String())
+	}
+
+	// Handlers that log with the same context while the buffer is flushed must not deadlock
+	buf.Reset()
+	ctx, commit = BufferRequest(context.Background(), BufferLimit(1))
+	logger.InfoContext(ctx, "first", "v", reentrantValue{logger, ctx})
+	logger.InfoContext(ctx, "second", "v", reentrantValue{logger, ctx})
+	commit(false)
+	for _, want := range []string{`"msg":"first"`, `"msg":"second"`, `"msg":"nested"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output should contain %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestCanonicalLine(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvProduction), WithContextKeys(KeyRequestID))
+
+	ctx, line := StartCanonical(WithRequestID(context.Background(), "req-7"), log, "canonical-log-line")
+	if Canonical(ctx) != line {
+		t.Fatal("Canonical() should return the started line")
+	}
+	Canonical(context.Background()).AddAttrs("ignored", true) // nil line
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Canonical(ctx).Count("db_queries", 1)
+			Canonical(ctx).AddDuration("db", time.Millisecond)
+		}()
+	}
+	wg.Wait()
+	line.AddAttrs("status", 200, slog.String("route", "/orders"))
+	line.AddAttrs("status", 500)
+	line.SetLevel(LevelError)
+	line.Emit()
+	line.Emit()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(lines))
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	want := map[string]any{
+		slog.LevelKey:   "ERROR",
+		slog.MessageKey: "canonical-log-line",
+		"request_id":    "req-7",
+		"status":        float64(500),
+		"route":         "/orders",
+		"db_queries":    float64(10),
+		"db":            float64(10 * time.Millisecond),
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s = %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Error("duration should be present")
+	}
+}
+
+func TestCanonicalLineLevelAndSource(t *testing.T) {
+	for _, level := range []Level{LevelTrace, LevelNotice, LevelCritical} {
+		var buf bytes.Buffer
+		log := New(WithOutput(&buf), WithEnv(EnvProduction), WithLevel(LevelTrace), WithSource(true))
+		_, line := StartCanonical(context.Background(), log, "canonical")
+		line.SetLevel(level)
+		line.Emit()
+
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("failed to parse JSON: %v", err)
+		}
+		if want := levelName(level); entry[slog.LevelKey] != want {
+			t.Errorf("level = %v, want %s", entry[slog.LevelKey], want)
+		}
+		source, _ := entry[slog.SourceKey].(map[string]any)
+		if file, _ := source["file"].(string); filepath.Base(file) != "xlogging_test.go" {
+			t.Errorf("source file = %v, want the caller of Emit", source["file"])
+		}
+	}
+
+	var buf bytes.Buffer
+	_, line := StartCanonical(context.Background(), New(WithOutput(&buf), WithEnv(EnvProduction), WithLevel(LevelInfo)), "canonical")
+	line.SetLevel(LevelDebug)
+	line.Emit()
+	if buf.Len() != 0 {
+		t.Errorf("line below the level should not be logged, got %s", buf.String())
+	}
+
+	buf.Reset()
+	ctx := IntoContext(context.Background(), New(WithOutput(&buf), WithEnv(EnvProduction)))
+	_, line = StartCanonical(ctx, nil, "canonical")
+	line.Emit()
+	if !strings.Contains(buf.String(), `"msg":"canonical"`) {
+		t.Errorf("nil Logger should log with the Logger of the context, got %q", buf.String())
+	}
+
+	tl := NewTestLogger()
+	_, line = StartCanonical(context.Background(), tl, "canonical")
+	line.SetLevel(LevelNotice)
+	line.Emit()
+	if !tl.HasEntry(LevelNotice, "canonical") {
+		t.Errorf("TestLogger entries = %v, want a NOTICE entry", tl.Entries())
+	}
+}
+
+func TestColorHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithLevel(LevelDebug),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+	)
+
+	log.Debug("debug message")
+	log.Info("info message")
+	log.Warn("warn message")
+	log.Error("error message")
+
+	output := buf.String()
+	if !strings.Contains(output, "debug message") {
+		t.Error("debug message should be present")
+	}
+	if !strings.Contains(output, "info message") {
+		t.Error("info message should be present")
+	}
+	if !strings.Contains(output, "warn message") {
+		t.Error("warn message should be present")
+	}
+	if !strings.Contains(output, "error message") {
+		t.Error("error message should be present")
+	}
+
+	// Check that ANSI codes are present
+	if !strings.Contains(output, "\033[") {
+		t.Error("ANSI color codes should be present")
+	}
+}
+
+func TestTextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithLevel(LevelInfo),
+		WithEnv(EnvDevelopment),
+		WithColor(false),
+	)
+
+	log.Info("text message", "key", "value")
+
+	output := buf.String()
+	if !strings.Contains(output, "text message") {
+		t.Error("message should be present")
+	}
+	if !strings.Contains(output, "key=value") {
+		t.Error("attribute should be present")
+	}
+}
+
+func TestTestLogger(t *testing.T) {
+	log := NewTestLogger()
+
+	log.Debug("debug")
+	log.Info("info")
+	log.Warn("warn")
+	log.Error("error")
+
+	if log.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", log.Len())
+	}
+
+	if !log.HasEntry(LevelDebug, "debug") {
+		t.Error("should have debug entry")
+	}
+	if !log.HasEntry(LevelInfo, "info") {
+		t.Error("should have info entry")
+	}
+	if !log.HasEntry(LevelWarn, "warn") {
+		t.Error("should have warn entry")
+	}
+	if !log.HasEntry(LevelError, "error") {
+		t.Error("should have error entry")
+	}
+
+	if log.Count(LevelInfo) != 1 {
+		t.Errorf("Count(LevelInfo) = %d, want 1", log.Count(LevelInfo))
+	}
+}
+
+func TestTestLoggerWithAttrs(t *testing.T) {
+	log := NewTestLogger()
+
+	log.Info("message", "key", "value")
+
+	if !log.HasEntryWithAttr(LevelInfo, "message", "key", "value") {
+		t.Error("should have entry with attribute")
+	}
+}
+
+func TestTestLoggerWith(t *testing.T) {
+	log := NewTestLogger()
+
+	childLog := log.With("service", "api")
 	childLog.Info("child message")
 
 	if !log.HasEntryWithAttr(LevelInfo, "child", "service", "api") {
@@ -310,6 +1257,38 @@ func TestTestLoggerWith(t *testing.T) {
 	}
 }
 
+func TestTestLoggerFatal(t *testing.T) {
+	log := NewTestLogger()
+
+	if _, exited := log.ExitCode(); exited {
+		t.Error("ExitCode() should report no exit before Fatal")
+	}
+
+	log.With("service", "api").Fatal("fatal message")
+
+	if code, exited := log.ExitCode(); !exited || code != 1 {
+		t.Errorf("ExitCode() = %d, %v, want 1, true", code, exited)
+	}
+	if !log.HasEntryWithAttr(LevelFatal, "fatal", "service", "api") {
+		t.Error("should have fatal entry")
+	}
+}
+
+func TestTestLoggerPanic(t *testing.T) {
+	log := NewTestLogger()
+
+	defer func() {
+		if r := recover(); r != "panic message" {
+			t.Errorf("recover() = %v, want %q", r, "panic message")
+		}
+		if !log.HasEntry(LevelPanic, "panic message") {
+			t.Error("should have panic entry")
+		}
+	}()
+
+	log.Panic("panic message")
+}
+
 func TestTestLoggerClear(t *testing.T) {
 	log := NewTestLogger()
 
@@ -357,3 +1336,1370 @@ func TestConfigOptions(t *testing.T) {
 		t.Error("Handler() should not be nil")
 	}
 }
+
+func TestLoggerName(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvProduction),
+		WithLevel(LevelInfo),
+		WithLevelFor("db", LevelDebug),
+		WithName("db"),
+	)
+
+	log.Debug("query")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if entry["logger"] != "db" {
+		t.Errorf("logger = %v, want %q", entry["logger"], "db")
+	}
+}
+
+func TestFormatOption(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithFormat(FormatJSON),
+	)
+
+	log.Info("json message")
+
+	if !json.Valid(buf.Bytes()) {
+		t.Errorf("output should be JSON, got %q", buf.String())
+	}
+}
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvProduction),
+		WithSampling(Sampling{Tick: time.Hour, First: 2, Thereafter: 3}),
+	)
+
+	for i := 0; i < 10; i++ {
+		log.Info("repeated")
+		log.Error("failure")
+	}
+
+	// first 2, then the 5th and 8th
+	if n := strings.Count(buf.String(), "repeated"); n != 4 {
+		t.Errorf("sampled info count = %d, want 4", n)
+	}
+	if n := strings.Count(buf.String(), "failure"); n != 10 {
+		t.Errorf("error count = %d, want 10", n)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvProduction),
+		WithRedact("password", "Token"),
+	)
+
+	log.With("token", "abc").Info("login",
+		"user", "alice",
+		slog.Group("auth", slog.String("PASSWORD", "secret")),
+	)
+
+	output := buf.String()
+	if strings.Contains(output, "secret") || strings.Contains(output, "abc") {
+		t.Errorf("sensitive values should be redacted, got %q", output)
+	}
+	if !strings.Contains(output, "alice") {
+		t.Errorf("other values should be kept, got %q", output)
+	}
+	if strings.Count(output, redactedValue) != 2 {
+		t.Errorf("output should contain two redacted values, got %q", output)
+	}
+}
+
+func TestRedactColor(t *testing.T) {
+	tokenKey := NewContextKey[string]("token")
+	extract := func(ctx context.Context) []slog.Attr {
+		return []slog.Attr{slog.String("password", "extracted")}
+	}
+	ctx := AppendCtx(tokenKey.With(context.Background(), "secret-token"), "password", "hunter2")
+
+	for _, group := range []string{"", "ctx"} {
+		var buf bytes.Buffer
+		log := New(
+			WithOutput(&buf),
+			WithColor(true),
+			WithRedact("password", "token"),
+			WithContextKeys(tokenKey.Key()),
+			WithContextExtractor(extract),
+			WithContextOutput(ContextOutput{Group: group}),
+		)
+		log.InfoContext(ctx, "login", "user", "alice", "password", "record")
+
+		output := buf.String()
+		for _, secret := range []string{"secret-token", "hunter2", "extracted", "record"} {
+			if strings.Contains(output, secret) {
+				t.Errorf("group %q: %q should be redacted, got %q", group, secret, output)
+			}
+		}
+		if !strings.Contains(output, "alice") {
+			t.Errorf("group %q: other values should be kept, got %q", group, output)
+		}
+		if n := strings.Count(output, redactedValue); n != 4 {
+			t.Errorf("group %q: output should contain four redacted values, got %d in %q", group, n, output)
+		}
+	}
+}
+
+// writeConfigFile writes a configuration file into a temporary directory.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	path := writeConfigFile(t, "logging.yaml", `
+env: production
+level: warn
+levels:
+  db: debug
+format: json
+outputs: [`+logPath+`]
+context_keys: [request_id]
+redact: [password]
+sampling:
+  tick: 1m
+  first: 1
+`)
+
+	cf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	defer cf.Close()
+
+	log := New(cf.Option())
+	dbLog := New(cf.Option(), WithName("db"))
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	log.InfoContext(ctx, "filtered")
+	log.WarnContext(ctx, "kept", "password", "secret")
+	log.Warn("kept") // sampled out
+	dbLog.Debug("query")
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), data)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if entry["request_id"] != "req-1" || entry["password"] != redactedValue {
+		t.Errorf("entry = %v, want request_id and redacted password", entry)
+	}
+	if !strings.Contains(lines[1], `"logger":"db"`) {
+		t.Errorf("second line should come from db logger, got %q", lines[1])
+	}
+}
+
+func TestLoadConfigJSONErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown field": `{"levle": "debug"}`,
+		"invalid level": `{"level": "debgu"}`,
+		"invalid env":   `{"env": "prdo"}`,
+		"invalid tick":  `{"sampling": {"tick": "soon"}}`,
+		"two values":    `{"level": "info"} {"level": "debug"}`,
+		"trailing data": `{"level": "info"}garbage`,
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfigFile(t, "logging.json", content)
+			if _, err := LoadConfig(path); err == nil {
+				t.Error("LoadConfig() should fail")
+			}
+		})
+	}
+}
+
+func TestLoadConfigYAMLDocuments(t *testing.T) {
+	path := writeConfigFile(t, "logging.yaml", "level: info\n---\nlevel: debug\n")
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("LoadConfig() should fail for several documents")
+	}
+}
+
+func TestConfigFileReload(t *testing.T) {
+	path := writeConfigFile(t, "logging.json", `{"env": "production", "level": "info"}`)
+
+	cf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	defer cf.Close()
+
+	var buf bytes.Buffer
+	log := New(cf.Option(), WithOutput(&buf))
+
+	log.Debug("before reload")
+	if err := os.WriteFile(path, []byte(`{"level": "debug", "redact": ["key"]}`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := cf.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	log.Debug("after reload", "key", "value")
+
+	output := buf.String()
+	if strings.Contains(output, "before reload") {
+		t.Error("debug message before reload should be filtered")
+	}
+	if !strings.Contains(output, "after reload") {
+		t.Error("debug message after reload should be present")
+	}
+	if strings.Contains(output, `"key":"value"`) {
+		t.Error("key should be redacted after reload")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"level": "debgu"}`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := cf.Reload(); err == nil {
+		t.Error("Reload() should fail for invalid level")
+	}
+}
+
+func TestConfigFileWatch(t *testing.T) {
+	path := writeConfigFile(t, "logging.json", `{"env": "production", "level": "error"}`)
+
+	cf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	defer cf.Close()
+
+	log := New(cf.Option(), WithOutput(io.Discard))
+	stop := cf.Watch(5*time.Millisecond, func(err error) { t.Errorf("Watch() error = %v", err) })
+	defer stop()
+
+	if err := os.WriteFile(path, []byte(`{"env": "production", "level": "debug", "format": "json"}`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !log.Handler().Enabled(context.Background(), LevelDebug) {
+		if time.Now().After(deadline) {
+			t.Fatal("level change was not applied")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConfigFileLevelFor(t *testing.T) {
+	cf, err := LoadConfig(writeConfigFile(t, "logging.json", `{"level": "warn", "levels": {"db": "error"}}`))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	defer cf.Close()
+
+	log := New(cf.Option(), WithLevelFor("db", LevelDebug), WithName("db"), WithOutput(io.Discard))
+	if !log.Handler().Enabled(context.Background(), LevelDebug) {
+		t.Error("WithLevelFor should override the file's level for the name")
+	}
+	log = New(cf.Option(), WithLevelFor("db", LevelDebug), WithName("api"), WithOutput(io.Discard))
+	if log.Handler().Enabled(context.Background(), LevelInfo) {
+		t.Error("other names should keep the file's level")
+	}
+}
+
+func TestConfigFileUnsetFields(t *testing.T) {
+	t.Setenv("XLOG_FORMAT", "json")
+	t.Setenv("XLOG_SOURCE", "true")
+	t.Setenv("XLOG_REDACT", "password")
+
+	path := writeConfigFile(t, "logging.json", `{"level": "info"}`)
+	cf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	defer cf.Close()
+
+	var buf bytes.Buffer
+	log := New(cf.Option(), WithOutput(&buf))
+	log.Info("env", "password", "secret", "token", "abc")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("XLOG_FORMAT should apply, failed to parse JSON: %v", err)
+	}
+	if entry["password"] != redactedValue {
+		t.Errorf("password = %v, want it redacted by XLOG_REDACT", entry["password"])
+	}
+	if _, ok := entry[slog.SourceKey]; !ok {
+		t.Error("XLOG_SOURCE should apply")
+	}
+
+	// Once the file sets redaction, it replaces that of the environment
+	if err := os.WriteFile(path, []byte(`{"level": "info", "redact": ["token"]}`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := cf.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	buf.Reset()
+	log.Info("file", "password", "secret", "token", "abc")
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if entry["password"] != "secret" || entry["token"] != redactedValue {
+		t.Errorf("entry = %v, want only token redacted", entry)
+	}
+}
+
+func TestLoadConfigEmpty(t *testing.T) {
+	for _, name := range []string{"logging.json", "logging.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			cf, err := LoadConfig(writeConfigFile(t, name, ""))
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			defer cf.Close()
+			stop := cf.Watch(0, nil) // default interval
+			stop()
+		})
+	}
+}
+
+func TestConfigFileEnvPrefix(t *testing.T) {
+	t.Setenv("XLOG_LEVEL", "debug")
+	t.Setenv("BILLING_LEVEL", "error")
+
+	cf, err := LoadConfig(writeConfigFile(t, "logging.json", `{"format": "json"}`))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	defer cf.Close()
+
+	log := New(WithEnvPrefix("BILLING_"), cf.Option(), WithOutput(io.Discard))
+	if log.Handler().Enabled(context.Background(), LevelWarn) {
+		t.Error("level should be read from BILLING_LEVEL")
+	}
+	log = New(cf.Option(), WithOutput(io.Discard))
+	if !log.Handler().Enabled(context.Background(), LevelDebug) {
+		t.Error("level should be read from XLOG_LEVEL")
+	}
+
+	t.Setenv("BILLING_LEVEL", "")
+	cf, err = LoadConfig(writeConfigFile(t, "logging.json", `{"env": "production"}`))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	defer cf.Close()
+	log = New(WithEnvPrefix("BILLING_"), cf.Option(), WithOutput(io.Discard))
+	if log.Handler().Enabled(context.Background(), LevelDebug) {
+		t.Error("level should default to the level of the file's environment")
+	}
+}
+
+func TestEnvVars(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	t.Setenv("XLOG_ENV", "development")
+	t.Setenv("XLOG_FORMAT", "json")
+	t.Setenv("XLOG_OUTPUT", logPath)
+	t.Setenv("XLOG_SOURCE", "true")
+	t.Setenv("XLOG_CONTEXT_KEYS", "request_id, trace_id")
+	t.Setenv("XLOG_SAMPLING", "first=1,tick=1h")
+	t.Setenv("XLOG_REDACT", "password")
+
+	log := Default()
+	ctx := WithTraceID(context.Background(), "trace-1")
+	log.InfoContext(ctx, "env message", "password", "secret")
+	log.InfoContext(ctx, "env message") // sampled out
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("failed to parse JSON %q: %v", data, err)
+	}
+	if entry["trace_id"] != "trace-1" {
+		t.Errorf("trace_id = %v, want %q", entry["trace_id"], "trace-1")
+	}
+	if entry["password"] != redactedValue {
+		t.Errorf("password = %v, want %q", entry["password"], redactedValue)
+	}
+	if _, ok := entry["source"]; !ok {
+		t.Error("source should be present")
+	}
+}
+
+func TestEnvVarsInvalid(t *testing.T) {
+	t.Setenv("XLOG_ENV", "prdo")
+	t.Setenv("XLOG_FORMAT", "xml")
+	t.Setenv("XLOG_COLOR", "maybe")
+	t.Setenv("XLOG_SAMPLING", "first=many")
+
+	var buf bytes.Buffer
+	New(WithOutput(&buf), WithColor(false))
+
+	output := buf.String()
+	for _, want := range []string{"XLOG_ENV", "XLOG_FORMAT", "XLOG_COLOR", "XLOG_SAMPLING"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output should report %s, got %q", want, output)
+		}
+	}
+}
+
+func TestWithEnvPrefix(t *testing.T) {
+	t.Setenv("XLOG_LEVEL", "debug")
+	t.Setenv("BILLING_LEVEL", "error")
+	t.Setenv("BILLING_FORMAT", "json")
+
+	var buf bytes.Buffer
+	log := New(WithEnvPrefix("BILLING_"), WithOutput(&buf))
+
+	log.Warn("filtered")
+	log.Error("kept")
+
+	output := buf.String()
+	if strings.Contains(output, "filtered") {
+		t.Error("warn message should be filtered by BILLING_LEVEL")
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Errorf("output should be JSON, got %q", output)
+	}
+
+	// Explicit options take precedence over the environment
+	buf.Reset()
+	log = New(WithOutput(&buf), WithEnvPrefix("BILLING_"), WithLevel(LevelWarn))
+	log.Warn("kept")
+	if !strings.Contains(buf.String(), "kept") {
+		t.Error("WithLevel should override BILLING_LEVEL")
+	}
+}
+
+func TestEnvOverriddenByOptions(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "env.log")
+	t.Setenv("XLOG_OUTPUT", logPath)
+	t.Setenv("XLOG_LEVEL", "verbose")
+
+	cf, err := LoadConfig(writeConfigFile(t, "logging.json", `{"format": "text"}`))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	defer cf.Close()
+
+	applied := 0
+	var buf bytes.Buffer
+	New(cf.Option(), WithOutput(&buf), WithColor(false), Option(func(*config) { applied++ }))
+
+	if applied != 1 {
+		t.Errorf("option applied %d times, want 1", applied)
+	}
+	if n := strings.Count(buf.String(), "XLOG_LEVEL"); n != 1 {
+		t.Errorf("XLOG_LEVEL reported %d times, want 1: %q", n, buf.String())
+	}
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Errorf("XLOG_OUTPUT should not be opened when WithOutput overrides it, Stat() error = %v", err)
+	}
+}
+
+func TestColorDepthFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		w        io.Writer
+		expected ColorDepth
+	}{
+		{"plain writer", nil, &bytes.Buffer{}, ColorDepthNone},
+		{"no color wins", map[string]string{"NO_COLOR": "1", "FORCE_COLOR": "1"}, &bytes.Buffer{}, ColorDepthNone},
+		{"force color", map[string]string{"FORCE_COLOR": "1"}, &bytes.Buffer{}, ColorDepth16},
+		{"force 256", map[string]string{"FORCE_COLOR": "2"}, &bytes.Buffer{}, ColorDepth256},
+		{"force truecolor", map[string]string{"FORCE_COLOR": "3"}, &bytes.Buffer{}, ColorDepthTrueColor},
+		{"force disabled", map[string]string{"FORCE_COLOR": "0", "CLICOLOR_FORCE": "1"}, &bytes.Buffer{}, ColorDepthNone},
+		{"clicolor force", map[string]string{"CLICOLOR_FORCE": "1", "TERM": "xterm-256color"}, &bytes.Buffer{}, ColorDepth256},
+		{"colorterm", map[string]string{"CLICOLOR_FORCE": "1", "COLORTERM": "truecolor"}, &bytes.Buffer{}, ColorDepthTrueColor},
+		{"ci stderr", map[string]string{"GITHUB_ACTIONS": "true"}, os.Stderr, ColorDepth16},
+		{"ci buffer", map[string]string{"CI": "true"}, &bytes.Buffer{}, ColorDepthNone},
+		{"dumb ci", map[string]string{"CI": "true", "TERM": "dumb"}, os.Stderr, ColorDepthNone},
+		{"clicolor off", map[string]string{"CI": "true", "CLICOLOR": "0"}, os.Stdout, ColorDepthNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string { return tt.env[key] }
+			if got := colorDepthFromEnv(tt.w, getenv); got != tt.expected {
+				t.Errorf("colorDepthFromEnv() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestColorForDepth(t *testing.T) {
+	tests := []struct {
+		color    Color
+		depth    ColorDepth
+		expected Color
+	}{
+		{ColorRed, ColorDepth16, ColorRed},
+		{ColorRed, ColorDepthNone, ColorNone},
+		{"\033[38;5;196m", ColorDepth256, "\033[38;5;196m"},
+		{"\033[38;5;196m", ColorDepth16, "\033[91m"},
+		{"\033[1;38;2;0;0;255m", ColorDepth256, "\033[1;38;5;21m"},
+		{"\033[38;2;10;10;10m", ColorDepth16, "\033[30m"},
+		{"\033[48;2;255;255;255m", ColorDepth16, "\033[107m"},
+		{"\033[38;2;1;2;3m", ColorDepthTrueColor, "\033[38;2;1;2;3m"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.color.forDepth(tt.depth); got != tt.expected {
+			t.Errorf("%q.forDepth(%v) = %q, want %q", tt.color, tt.depth, got, tt.expected)
+		}
+	}
+}
+
+func TestColorAutoDetect(t *testing.T) {
+	t.Setenv("FORCE_COLOR", "1")
+
+	var buf bytes.Buffer
+	New(WithOutput(&buf), WithEnv(EnvDevelopment)).Info("forced")
+	if !strings.Contains(buf.String(), "\033[") {
+		t.Errorf("FORCE_COLOR should enable colors, got %q", buf.String())
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	buf.Reset()
+	New(WithOutput(&buf), WithEnv(EnvDevelopment)).Info("disabled")
+	if strings.Contains(buf.String(), "\033[") {
+		t.Errorf("NO_COLOR should disable colors, got %q", buf.String())
+	}
+}
+
+func TestTheme(t *testing.T) {
+	t.Setenv("COLORTERM", "truecolor")
+
+	var buf bytes.Buffer
+	theme := DarkTheme()
+	theme.Key = RGB(1, 2, 3)
+	theme.Levels = map[Level]Color{LevelInfo: Color256(200)}
+	theme.Error = ColorMagenta
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithTheme(theme),
+	)
+
+	log.Info("themed", "err", errors.New("boom"))
+
+	output := buf.String()
+	for _, want := range []string{"\033[38;2;1;2;3merr", "\033[38;5;200m", string(ColorMagenta) + "boom"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output should contain %q, got %q", want, output)
+		}
+	}
+}
+
+func TestMonochromeTheme(t *testing.T) {
+	t.Setenv("XLOG_THEME", "mono")
+
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvDevelopment), WithColor(true))
+	log.Error("plain", "key", "value")
+
+	if regexp.MustCompile(`\x1b\[[0-9;]*3[0-9]m`).MatchString(buf.String()) {
+		t.Errorf("monochrome output should not contain foreground colors, got %q", buf.String())
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithHighlightKeys("order.id"),
+		WithHighlightValues("needle"),
+	)
+
+	highlight := string(DarkTheme().Highlight)
+	log.WithGroup("order").Info("highlighted", "id", 42, "note", "find the needle")
+
+	output := buf.String()
+	if !strings.Contains(output, highlight+"order.id") {
+		t.Errorf("key should be highlighted, got %q", output)
+	}
+	if !strings.Contains(output, highlight+`"find the needle"`) {
+		t.Errorf("value should be highlighted, got %q", output)
+	}
+}
+
+// ansiPattern matches ANSI color escape sequences.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// parseColorLine parses a line of colorHandler output into the form expected by slogtest.
+func parseColorLine(t *testing.T, line string) map[string]any {
+	t.Helper()
+
+	fields := splitColorFields(t, ansiPattern.ReplaceAllString(strings.TrimSuffix(line, "\n"), ""))
+	m := make(map[string]any)
+	if len(fields) > 0 {
+		if _, err := time.Parse(time.TimeOnly, fields[0]); err == nil {
+			m[slog.TimeKey] = fields[0]
+			fields = fields[1:]
+		}
+	}
+	if len(fields) < 2 {
+		t.Fatalf("malformed line %q", line)
+	}
+	m[slog.LevelKey] = fields[0]
+	m[slog.MessageKey] = fields[1]
+
+	for _, field := range fields[2:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			t.Fatalf("malformed attribute %q in line %q", field, line)
+		}
+		group := m
+		path := strings.Split(key, ".")
+		for _, name := range path[:len(path)-1] {
+			sub, ok := group[name].(map[string]any)
+			if !ok {
+				sub = make(map[string]any)
+				group[name] = sub
+			}
+			group = sub
+		}
+		group[path[len(path)-1]] = value
+	}
+	return m
+}
+
+// splitColorFields splits a line at spaces, unquoting quoted values.
+func splitColorFields(t *testing.T, line string) []string {
+	t.Helper()
+
+	var fields []string
+	var field strings.Builder
+	inField := false
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+			i++
+		case c == '"':
+			quoted, err := strconv.QuotedPrefix(line[i:])
+			if err != nil {
+				t.Fatalf("malformed quoted value in line %q: %v", line, err)
+			}
+			unquoted, _ := strconv.Unquote(quoted)
+			field.WriteString(unquoted)
+			inField = true
+			i += len(quoted)
+		default:
+			field.WriteByte(c)
+			inField = true
+			i++
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+func TestColorHandlerConformance(t *testing.T) {
+	var buf bytes.Buffer
+	slogtest.Run(t,
+		func(*testing.T) slog.Handler {
+			buf.Reset()
+			return newColorHandler(&buf, &colorHandlerOptions{AddSource: true})
+		},
+		func(t *testing.T) map[string]any {
+			return parseColorLine(t, strings.TrimSuffix(buf.String(), "\n"))
+		},
+	)
+}
+
+func TestContextHandlerConformance(t *testing.T) {
+	var buf bytes.Buffer
+	slogtest.Run(t,
+		func(*testing.T) slog.Handler {
+			buf.Reset()
+			return newContextHandler(slog.NewJSONHandler(&buf, nil), []ContextKey{KeyRequestID}, nil, nil)
+		},
+		func(t *testing.T) map[string]any {
+			var m map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+				t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+			}
+			return m
+		},
+	)
+}
+
+func TestColorHandlerGroups(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newColorHandler(&buf, nil))
+
+	log.With("a", 1).WithGroup("g").With("b", 2).WithGroup("empty").Info("msg", slog.Group("", "c", 3))
+
+	got := parseColorLine(t, buf.String())
+	want := map[string]any{"a": "1", "g": map[string]any{"b": "2", "empty": map[string]any{"c": "3"}}}
+	for key, value := range want {
+		if !reflect.DeepEqual(got[key], value) {
+			t.Errorf("%s = %#v, want %#v", key, got[key], value)
+		}
+	}
+}
+
+// countingWriter counts Write calls.
+type countingWriter struct {
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return len(p), nil
+}
+
+func TestColorHandlerSingleWrite(t *testing.T) {
+	var w countingWriter
+	log := New(
+		WithOutput(&w),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithSource(true),
+		WithContextKeys(KeyRequestID),
+	).With("service", "api")
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	log.InfoContext(ctx, "first", "n", 1, "ok", true, "d", time.Second)
+	log.WarnContext(ctx, "second", slog.Group("g", "err", errors.New("boom")))
+
+	if w.writes != 2 {
+		t.Errorf("writes = %d, want 2", w.writes)
+	}
+}
+
+func TestColorHandlerValues(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newColorHandler(&buf, nil))
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	log.Info("values",
+		"s", "str", "i", -3, "u", uint64(7), "f", 1.5, "b", true,
+		"d", 1500*time.Millisecond, "t", ts, "err", errors.New("boom"), "nil", nil, "any", struct{ X int }{1})
+
+	got := parseColorLine(t, buf.String())
+	want := map[string]string{
+		"s": "str", "i": "-3", "u": "7", "f": "1.5", "b": "true",
+		"d": "1.5s", "t": "2024-01-02T03:04:05Z", "err": "boom", "nil": "<nil>", "any": "{1}",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %q", key, got[key], value)
+		}
+	}
+}
+
+func benchmarkHandler(b *testing.B, h slog.Handler) {
+	log := slog.New(h).With("service", "api", "version", 3)
+	err := errors.New("connection reset")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		log.Info("request handled",
+			"method", "GET",
+			"status", 200,
+			"duration", 1250*time.Microsecond,
+			"cached", false,
+			slog.Group("client", "ip", "10.0.0.1", "err", err),
+		)
+	}
+}
+
+func BenchmarkColorHandler(b *testing.B) {
+	benchmarkHandler(b, newColorHandler(io.Discard, nil))
+}
+
+func BenchmarkColorHandlerParallel(b *testing.B) {
+	log := slog.New(newColorHandler(io.Discard, nil))
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			log.Info("request handled", "method", "GET", "status", 200)
+		}
+	})
+}
+
+func BenchmarkTextHandler(b *testing.B) {
+	benchmarkHandler(b, slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestColorHandlerQuoting(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newColorHandler(&buf, &colorHandlerOptions{ContextKeys: []ContextKey{KeyUserID}}))
+
+	ctx := WithUserID(context.Background(), "jane doe")
+	log.InfoContext(ctx, "quoted", "space", "a b", "eq", "k=v", "empty", "", "multi", "x\ny", "plain", "ok")
+
+	got := parseColorLine(t, buf.String())
+	want := map[string]string{"user_id": "jane doe", "space": "a b", "eq": "k=v", "empty": "", "multi": "x\ny", "plain": "ok"}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("compact output should be one line, got %q", buf.String())
+	}
+}
+
+func TestColorHandlerExpanded(t *testing.T) {
+	type address struct {
+		City string
+		Zip  int
+	}
+	type user struct {
+		Name    string
+		Tags    []string
+		Address *address
+		secret  string
+	}
+
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithExpanded(true),
+	).With("owner", user{Name: "bob"})
+
+	log.Info("expanded",
+		"user", user{Name: "jane", Tags: []string{"admin", "ops"}, Address: &address{City: "Oslo", Zip: 150}, secret: "x"},
+		"payload", json.RawMessage(`{"id":1,"items":[2,3]}`),
+		"stack", "line one\nline two",
+		"ids", make([]int, expandMaxItems+5),
+		"status", 200,
+	)
+
+	output := ansiPattern.ReplaceAllString(buf.String(), "")
+	lines := strings.Split(output, "\n")
+	if !strings.HasSuffix(lines[0], "expanded status=200") {
+		t.Errorf("first line should contain only scalar attributes, got %q", lines[0])
+	}
+	for _, want := range []string{
+		"  owner:\n    Name: bob\n    Tags: <nil>\n    Address: <nil>\n",
+		"  user:\n    Name: jane\n    Tags:\n      - admin\n      - ops\n    Address:\n      City: Oslo\n      Zip: 150\n",
+		"  payload:\n    {\n      \"id\": 1,\n      \"items\": [\n        2,\n        3\n      ]\n    }\n",
+		"  stack:\n    line one\n    line two\n",
+		"    ... (5 more)\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output should contain %q, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "secret") {
+		t.Errorf("unexported fields should not be rendered, got:\n%s", output)
+	}
+}
+
+func TestColorHandlerExpandedStructMore(t *testing.T) {
+	type wide struct {
+		A, B, C, D, E, F, G, H, I, J, K, L, M, N, O, P, Q, R, S, T, U, V int
+		x, y, z                                                          int
+	}
+
+	var buf bytes.Buffer
+	log := slog.New(newColorHandler(&buf, &colorHandlerOptions{Expanded: true}))
+	log.Info("wide", "value", wide{x: 1, y: 2, z: 3})
+
+	output := ansiPattern.ReplaceAllString(buf.String(), "")
+	if !strings.Contains(output, "    ... (2 more)\n") {
+		t.Errorf("only exported fields should be counted, got:\n%s", output)
+	}
+}
+
+func TestColorHandlerExpandedDepth(t *testing.T) {
+	type node struct {
+		Next *node
+	}
+	n := &node{}
+	n.Next = n // cycle
+
+	var buf bytes.Buffer
+	log := slog.New(newColorHandler(&buf, &colorHandlerOptions{Expanded: true}))
+	log.Info("cycle", "node", n)
+
+	output := ansiPattern.ReplaceAllString(buf.String(), "")
+	if got := strings.Count(output, "Next:"); got != expandMaxDepth {
+		t.Errorf("rendered %d levels, want %d:\n%s", got, expandMaxDepth, output)
+	}
+	if !strings.Contains(output, "Next: ...") {
+		t.Errorf("deep values should be elided, got:\n%s", output)
+	}
+}
+
+func TestColorHandlerPresetTime(t *testing.T) {
+	var buf bytes.Buffer
+	started := time.Now().Add(-5 * time.Second)
+	log := New(WithOutput(&buf), WithColor(true), WithExpanded(true))
+	log.With("started", started, "job", map[string]any{"at": started}).Info("running", "since", started)
+
+	fields := parseColorLine(t, strings.SplitN(buf.String(), "\n", 2)[0])
+	if want := started.Format(time.RFC3339Nano); fields["started"] != want {
+		t.Errorf("preset started = %v, want absolute %v", fields["started"], want)
+	}
+	if fields["since"] != "5s ago" {
+		t.Errorf("record since = %v, want relative 5s ago", fields["since"])
+	}
+	if !strings.Contains(ansiPattern.ReplaceAllString(buf.String(), ""), "at: "+started.Format(time.RFC3339Nano)) {
+		t.Errorf("preset block should render the time absolutely, got %q", buf.String())
+	}
+}
+
+func TestHumanizedValues(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"nanoseconds", appendDuration(nil, 350*time.Nanosecond), "350ns"},
+		{"microseconds", appendDuration(nil, 1500*time.Nanosecond), "1.5µs"},
+		{"milliseconds", appendDuration(nil, 12345*time.Microsecond), "12.3ms"},
+		{"seconds", appendDuration(nil, 1234*time.Millisecond), "1.23s"},
+		{"whole seconds", appendDuration(nil, 2*time.Second), "2s"},
+		{"minutes", appendDuration(nil, 62500*time.Millisecond), "1m3s"},
+		{"negative", appendDuration(nil, -1200*time.Millisecond), "-1.2s"},
+		{"bytes", appendBytes(nil, 512), "512 B"},
+		{"kibibytes", appendBytes(nil, 1536), "1.5 KiB"},
+		{"mebibytes", appendBytes(nil, 3565158), "3.4 MiB"},
+		{"gibibytes", appendBytes(nil, 1<<30), "1 GiB"},
+		{"negative bytes", appendBytes(nil, -1536), "-1.5 KiB"},
+		{"min int64", appendBytes(nil, math.MinInt64), "-8 EiB"},
+		{"float artifact", appendFloat(nil, 0.1+0.2), "0.3"},
+		{"float integer", appendFloat(nil, 42), "42"},
+		{"float small", appendFloat(nil, 0.0000123), "1.23e-05"},
+		{"float nan", appendFloat(nil, math.NaN()), "NaN"},
+		{"time ago", appendTime(nil, now.Add(-3*time.Minute-12*time.Second), now), "3m12s ago"},
+		{"time ahead", appendTime(nil, now.Add(5*time.Second), now), "in 5s"},
+		{"time now", appendTime(nil, now, now), "now"},
+		{"time far", appendTime(nil, now.Add(-48*time.Hour), now), "2023-12-31T03:04:05Z"},
+		{"clock", appendTimestamp(nil, TimeModeClock, now.Add(7*time.Millisecond), time.Time{}), "03:04:05.007"},
+		{"rfc3339", appendTimestamp(nil, TimeModeRFC3339, now, time.Time{}), "2024-01-02T03:04:05.000Z"},
+		{"elapsed", appendTimestamp(nil, TimeModeElapsed, processStart.Add(time.Hour+2*time.Minute+3045*time.Millisecond), time.Time{}), "01:02:03.045"},
+		{"delta", appendTimestamp(nil, TimeModeDelta, now.Add(15*time.Millisecond), now), "+0.015s"},
+		{"first delta", appendTimestamp(nil, TimeModeDelta, now, time.Time{}), "+0.000s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if string(tt.got) != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColorHandlerTimeMode(t *testing.T) {
+	t.Setenv("XLOG_TIME_MODE", "delta")
+
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvDevelopment), WithColor(true))
+	log.Info("first", "size", Bytes(2048), "ok", false)
+	log.Info("second")
+
+	lines := strings.Split(ansiPattern.ReplaceAllString(buf.String(), ""), "\n")
+	if !strings.HasPrefix(lines[0], "+0.000s INFO  first size=\"2 KiB\" ok=false") {
+		t.Errorf("first line = %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "+0.") {
+		t.Errorf("second line should start with a delta, got %q", lines[1])
+	}
+
+	t.Setenv("XLOG_TIME_MODE", "sometimes")
+	buf.Reset()
+	New(WithOutput(&buf), WithEnv(EnvDevelopment), WithColor(false))
+	if !strings.Contains(buf.String(), "XLOG_TIME_MODE") {
+		t.Errorf("invalid time mode should be reported, got %q", buf.String())
+	}
+}
+
+func TestAlignedLayout(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithContextKeys(KeyRequestID, KeyUserID),
+		WithLayout(Layout{MessageWidth: 16, Width: -1}),
+	)
+
+	ctx := WithUserID(WithRequestID(context.Background(), "req-1"), "u-1")
+	log.InfoContext(WithRequestID(context.Background(), "req-22"), "a longer message", "b", 2)
+	log.InfoContext(ctx, "short", "a", 1)
+	log.InfoContext(WithUserID(context.Background(), "u-1"), "missing request", "c", 3)
+
+	lines := strings.Split(strings.TrimSuffix(ansiPattern.ReplaceAllString(buf.String(), ""), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), buf.String())
+	}
+	column := func(line, s string) int {
+		i := strings.Index(line, s)
+		if i < 0 {
+			t.Fatalf("%q not found in %q", s, line)
+		}
+		return utf8.RuneCountInString(line[:i])
+	}
+	if a, b := column(lines[0], "request_id="), column(lines[1], "request_id="); a != b {
+		t.Errorf("request_id columns differ: %d and %d", a, b)
+	}
+	if a, b := column(lines[1], "user_id="), column(lines[2], "user_id="); a != b {
+		t.Errorf("user_id columns differ: %d and %d", a, b)
+	}
+	if a, c := column(lines[1], "a="), column(lines[2], "c="); a != c {
+		t.Errorf("attribute columns differ: %d and %d", a, c)
+	}
+}
+
+func TestAlignedLayoutLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithLevel(LevelTrace),
+		WithLayout(Layout{MessageWidth: 16, Width: -1}),
+	)
+
+	sl := slog.New(log.Handler())
+	ctx := context.Background()
+	sl.Info("info")
+	sl.Log(ctx, LevelNotice, "notice")
+	sl.Log(ctx, LevelCritical, "critical")
+	sl.Log(ctx, LevelTrace, "trace")
+
+	lines := strings.Split(strings.TrimSuffix(ansiPattern.ReplaceAllString(buf.String(), ""), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4:\n%s", len(lines), buf.String())
+	}
+	want := strings.Index(lines[0], "info")
+	for i, msg := range []string{"info", "notice", "critical", "trace"} {
+		if got := strings.LastIndex(lines[i], msg); got != want {
+			t.Errorf("message %q at column %d, want %d:\n%s", msg, got, want, lines[i])
+		}
+	}
+}
+
+func TestAlignedLayoutAdaptive(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newColorHandler(&buf, &colorHandlerOptions{Layout: &Layout{Width: -1}}))
+
+	log.Info("a long message first", "a", 1)
+	log.Info("short", "b", 2)
+	log.Info(strings.Repeat("x", maxAdaptiveMessageWidth+1), "c", 3)
+	log.Info("short", "d", 4)
+
+	lines := strings.Split(ansiPattern.ReplaceAllString(buf.String(), ""), "\n")
+	if a, b := strings.Index(lines[0], "a="), strings.Index(lines[1], "b="); a != b {
+		t.Errorf("attribute columns differ: %d and %d", a, b)
+	}
+	if a, d := strings.Index(lines[0], "a="), strings.Index(lines[3], "d="); a != d {
+		t.Errorf("overlong message should not widen the column: %d and %d", a, d)
+	}
+}
+
+func TestAlignedLayoutWrap(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newColorHandler(&buf, &colorHandlerOptions{Layout: &Layout{MessageWidth: 8, Width: 70}})).
+		With("service", "checkout")
+
+	log.Info("wrapped", "method", "POST", "path", "/api/v1/orders", "status", 201, "duration", 1250*time.Millisecond)
+
+	lines := strings.Split(strings.TrimSuffix(ansiPattern.ReplaceAllString(buf.String(), ""), "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("attributes should wrap, got:\n%s", buf.String())
+	}
+	indent := strings.Index(lines[0], "service=")
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > 70 {
+			t.Errorf("line exceeds width (%d): %q", n, line)
+		}
+	}
+	for _, line := range lines[1:] {
+		if strings.TrimLeft(line, " ") == "" || len(line)-len(strings.TrimLeft(line, " ")) != indent {
+			t.Errorf("continuation line should be indented to column %d: %q", indent, line)
+		}
+	}
+	for _, want := range []string{"service=checkout", "method=POST", "path=/api/v1/orders", "status=201", "duration=1.25s"} {
+		if !strings.Contains(ansiPattern.ReplaceAllString(buf.String(), ""), want) {
+			t.Errorf("output should contain %q", want)
+		}
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    *Layout
+		wantErr bool
+	}{
+		{"aligned", &Layout{}, false},
+		{"aligned,message=30,width=120", &Layout{MessageWidth: 30, Width: 120}, false},
+		{"width=-1", &Layout{Width: -1}, false},
+		{"compact", nil, false},
+		{"message", nil, true},
+		{"columns=3", nil, true},
+		{"width=wide", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseLayout(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLayout(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLayout(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestColors(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithContextKeys(KeyRequestID),
+		WithRequestColors(RequestColors{Gutter: true}),
+		WithExpanded(true),
+	)
+
+	req1 := WithRequestID(context.Background(), "req-1")
+	req2 := WithRequestID(context.Background(), "req-2")
+	log.InfoContext(req1, "first")
+	log.InfoContext(req2, "second")
+	log.InfoContext(req1, "third", "stack", "a\nb")
+	log.Info("no request")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("got %d lines, want 7:\n%s", len(lines), buf.String())
+	}
+	colors := newRequestColorizer(RequestColors{}, ColorDepth16)
+	gutter1 := colors.color("req-1") + gutterMarker + colorReset
+	for _, i := range []int{0, 2, 3, 4, 5} {
+		if !strings.HasPrefix(lines[i], gutter1) {
+			t.Errorf("line %d should start with the gutter of req-1: %q", i, lines[i])
+		}
+	}
+	if !strings.HasPrefix(lines[1], colors.color("req-2")+gutterMarker) {
+		t.Errorf("line 1 should start with the gutter of req-2: %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[6], gutterBlank) {
+		t.Errorf("lines without a request should start with a blank gutter: %q", lines[6])
+	}
+	if !strings.Contains(lines[0], "="+colors.color("req-1")+"req-1") {
+		t.Errorf("request ID should be rendered in its color: %q", lines[0])
+	}
+}
+
+func TestRequestColorsTag(t *testing.T) {
+	t.Setenv("XLOG_REQUEST_COLORS", "key=trace_id,tag=4")
+
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvDevelopment), WithColor(true), WithContextKeys(KeyTraceID))
+	log.InfoContext(WithTraceID(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736"), "tagged")
+
+	colors := newRequestColorizer(RequestColors{Tag: 4}, ColorDepth16)
+	tag := string(colors.appendTag(nil, "4bf92f3577b34da6a3ce929d0e0e4736"))
+	if len(tag) != 5 || tag[0] != '#' {
+		t.Fatalf("tag = %q, want # and 4 hex digits", tag)
+	}
+	got := parseColorLine(t, buf.String())
+	if got["trace_id"] != tag {
+		t.Errorf("trace_id = %v, want %q", got["trace_id"], tag)
+	}
+	if got := string(colors.appendTag(nil, "4bf92f3577b34da6a3ce929d0e0e4736")); got != tag {
+		t.Errorf("tags should be stable, got %q and %q", tag, got)
+	}
+}
+
+func TestParseRequestColors(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    *RequestColors
+		wantErr bool
+	}{
+		{"on", &RequestColors{}, false},
+		{"gutter", &RequestColors{Gutter: true}, false},
+		{"key=trace_id,gutter,tag=6", &RequestColors{Key: KeyTraceID, Gutter: true, Tag: 6}, false},
+		{"off", nil, false},
+		{"rainbow", nil, true},
+		{"tag=long", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseRequestColors(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRequestColors(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRequestColors(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceLinks(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(wd, "xlogging_test.go")
+
+	var buf bytes.Buffer
+	log := New(
+		WithOutput(&buf),
+		WithEnv(EnvDevelopment),
+		WithColor(true),
+		WithSource(true),
+		WithSourceLinks(SourceLinks{URL: SourceLinkVSCode, ModuleRelative: true}),
+	)
+	log.Info("linked")
+
+	output := buf.String()
+	target := "vscode://file" + filepath.ToSlash(file) + ":"
+	if !strings.Contains(output, "\033]8;;"+target) {
+		t.Errorf("output should contain a hyperlink to %q, got %q", target, output)
+	}
+	if !strings.Contains(output, "\033\\xlogging_test.go:") {
+		t.Errorf("link text should be module-relative, got %q", output)
+	}
+	if !strings.Contains(output, "\033]8;;\033\\") {
+		t.Errorf("hyperlink should be closed, got %q", output)
+	}
+}
+
+func TestSourceLinksTarget(t *testing.T) {
+	links := SourceLinks{URL: SourceLinkIDEA}
+	if got, want := links.target("/src/my app/main.go", 12), "idea://open?file=/src/my%20app/main.go&line=12"; got != want {
+		t.Errorf("target = %q, want %q", got, want)
+	}
+	links.URL = SourceLinkFile
+	if got, want := links.target("/src/main.go", 3), "file:///src/main.go"; got != want {
+		t.Errorf("target = %q, want %q", got, want)
+	}
+	if got := visibleWidth(links.appendSourceLocation(nil, "/src/main.go", 3)); got != len("/src/main.go:3") {
+		t.Errorf("visible width = %d, want %d", got, len("/src/main.go:3"))
+	}
+}
+
+func TestParseSourceLinks(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    *SourceLinks
+		wantErr bool
+	}{
+		{"vscode", &SourceLinks{URL: SourceLinkVSCode}, false},
+		{"idea,relative", &SourceLinks{URL: SourceLinkIDEA, ModuleRelative: true}, false},
+		{"relative", &SourceLinks{ModuleRelative: true}, false},
+		{"subl://open?url=file://{path}&line={line}", &SourceLinks{URL: "subl://open?url=file://{path}&line={line}"}, false},
+		{"emacs", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSourceLinks(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSourceLinks(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSourceLinks(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColorHandlerSanitize(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(WithOutput(&buf), WithEnv(EnvDevelopment), WithColor(true))
+
+	log.WithGroup("g\x1b[2J").Info("login\n12:00:00 INFO  forged\x1b[31m\u202e",
+		"user\r", "bob\nINFO admin", "bad", "\xff")
+
+	output := buf.String()
+	if strings.Count(output, "\n") != 1 {
+		t.Fatalf("output should be one line, got %q", output)
+	}
+	if strings.ContainsAny(ansiPattern.ReplaceAllString(output, ""), "\x1b\r\u202e") {
+		t.Errorf("control characters should be escaped, got %q", output)
+	}
+	for _, want := range []string{`login\n12:00:00 INFO  forged\x1b[31m\u202e`, `g\x1b[2J.user\r`, `"bob\nINFO admin"`, `"\xff"`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output should contain %q, got %q", want, output)
+		}
+	}
+
+	buf.Reset()
+	log = New(WithOutput(&buf), WithEnv(EnvDevelopment), WithColor(true), WithSanitize(false))
+	log.Info("raw\x1b[1m")
+	if !strings.Contains(buf.String(), "raw\x1b[1m") {
+		t.Errorf("WithSanitize(false) should write messages verbatim, got %q", buf.String())
+	}
+}
+
+func FuzzHandlerSingleLine(f *testing.F) {
+	f.Add("message", "key", "value")
+	f.Add("line\nforged", "k\r", "\x1b[31mred\x1b[0m")
+	f.Add("\u202egnp.exe", "", "\xff\xfe")
+	f.Add("", "a=b", `"quoted"`)
+
+	f.Fuzz(func(t *testing.T, msg, key, value string) {
+		var buf bytes.Buffer
+		handlers := map[string]slog.Handler{
+			"color": newColorHandler(&buf, &colorHandlerOptions{ContextKeys: []ContextKey{KeyRequestID}}),
+			"text":  &textSanitizer{inner: slog.NewTextHandler(&buf, nil)},
+		}
+		for name, h := range handlers {
+			buf.Reset()
+			ctx := WithRequestID(context.Background(), value)
+			log := slog.New(h).WithGroup(key).With(key, value)
+			log.InfoContext(ctx, msg, key, value, "err", errors.New(value), slog.Group(key, key, value))
+
+			output := buf.String()
+			if strings.Count(output, "\n") != 1 || !strings.HasSuffix(output, "\n") {
+				t.Fatalf("%s: output is not exactly one line: %q", name, output)
+			}
+			for _, r := range ansiPattern.ReplaceAllString(strings.TrimSuffix(output, "\n"), "") {
+				if r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0) || isBidiControl(r) {
+					t.Fatalf("%s: output contains control character %U: %q", name, r, output)
+				}
+			}
+		}
+	})
+}